@@ -2,14 +2,17 @@ package api
 
 import (
 	"context"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"sync"
+	"wb-test-task/internal/config"
 	"wb-test-task/internal/db"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type ordkey string
@@ -17,32 +20,41 @@ type ordkey string
 const orderKey ordkey = "order"
 
 type Api struct {
+	cfg                config.HTTPConfig
 	rtr                *chi.Mux
 	csh                *db.Cache
 	name               string
+	checks             []HealthCheck
 	srv                *http.Server
 	httpServerExitDone *sync.WaitGroup
 }
 
-func NewApi(csh *db.Cache) *Api {
+func NewApi(cfg config.HTTPConfig, csh *db.Cache, checks []HealthCheck) *Api {
 	api := Api{}
-	api.Init(csh)
+	api.Init(cfg, csh, checks)
 	return &api
 }
 
 // Инициализация сервера
-func (a *Api) Init(csh *db.Cache) {
+func (a *Api) Init(cfg config.HTTPConfig, csh *db.Cache, checks []HealthCheck) {
+	a.cfg = cfg
 	a.csh = csh
+	a.checks = checks
 	a.name = "API"
 	a.rtr = chi.NewRouter()
+	a.rtr.Use(instrument)
 	a.rtr.Get("/", a.WellcomeHandler)
+	a.rtr.Handle("/metrics", promhttp.Handler()) // метрики Prometheus
+	a.rtr.Get("/healthz", a.Healthz)             // liveness
+	a.rtr.Get("/readyz", a.Readyz)               // readiness: Postgres, NATS, кеш
 
 	// RESTy routes https://github.com/go-chi/chi
-	a.rtr.Route("/orders", func(r chi.Router) {
-		r.Route("/{orderID}", func(r chi.Router) {
-			r.Use(a.orderCtx)
-			r.Get("/", a.GetOrder) // GET /orders/123
-		})
+	a.rtr.Route("/orders", a.orderRoutes(false)) // HTML или JSON при Accept: application/json, только чтение
+
+	// JSON API: полный Order с Payment и Items
+	a.rtr.Route("/api/v1", func(r chi.Router) {
+		r.Use(forceJSON)
+		r.Route("/orders", a.orderRoutes(true))
 	})
 
 	a.httpServerExitDone = &sync.WaitGroup{}
@@ -50,31 +62,59 @@ func (a *Api) Init(csh *db.Cache) {
 	a.StartServer()
 }
 
+// Маршруты получения Order: список, по id, по OrderUID и по TrackNumber.
+// Изменение Order (writable) доступно только в JSON API
+func (a *Api) orderRoutes(writable bool) func(chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", a.ListOrders) // GET /orders?brand=Nike&cursor=...
+		r.Route("/{orderID}", func(r chi.Router) {
+			r.Use(a.orderCtx)
+			r.Get("/", a.GetOrder) // GET /orders/123
+			if writable {
+				r.Put("/", a.UpdateOrder) // PUT /api/v1/orders/123
+			}
+			r.Get("/history", a.GetOrderHistory) // GET /orders/123/history
+		})
+		r.Route("/uid/{orderUID}", func(r chi.Router) {
+			r.Use(a.orderByUIDCtx)
+			r.Get("/", a.GetOrder) // GET /orders/uid/b563feb7b2b84b6test
+		})
+		r.Route("/track/{trackNumber}", func(r chi.Router) {
+			r.Use(a.orderByTrackCtx)
+			r.Get("/", a.GetOrder) // GET /orders/track/WBILMTESTTRACK
+		})
+	}
+}
+
 // Корректное завершение работы сервера
-func (a *Api) Finish() {
+func (a *Api) Finish(ctx context.Context) error {
 	log.Printf("%v: Выключение сервера...\n", a.name)
 
-	// now close the server gracefully ("shutdown")
-	if err := a.srv.Shutdown(context.Background()); err != nil {
-		panic(err) // failure/timeout shutting down the server gracefully
+	// now close the server gracefully ("shutdown"): ждем завершения активных запросов не дольше срока ctx,
+	// после чего закрываем оставшиеся соединения принудительно
+	err := a.srv.Shutdown(ctx)
+	if err != nil {
+		log.Printf("%v: graceful shutdown error: %v, closing connections\n", a.name, err)
+		a.srv.Close()
 	}
 
 	// wait for goroutine started in startHttpServer() to stop
 	a.httpServerExitDone.Wait()
 	log.Printf("%v: Сервер успешно выключен!\n", a.name)
+	return err
 }
 
 // Запуск сервера в отдельном потоке (для корректного завершения работы программы: очистка кеша из БД, отключение от подписки)
 func (a *Api) StartServer() {
 	a.srv = &http.Server{
-		Addr:    ":3333",
+		Addr:    a.cfg.Addr,
 		Handler: a.rtr,
 	}
 
 	go func() {
 		defer a.httpServerExitDone.Done() // let main know we are done cleaning up
 
-		log.Printf("%v: сервер будет запущен по адресу http://localhost:3333\n", a.name)
+		log.Printf("%v: сервер будет запущен по адресу %s\n", a.name, a.cfg.Addr)
 		// always returns error. ErrServerClosed on graceful close
 		if err := a.srv.ListenAndServe(); err != http.ErrServerClosed {
 			// unexpected error. port in use?
@@ -84,27 +124,56 @@ func (a *Api) StartServer() {
 	}()
 }
 
-// Мидлвара, сохраняющая в контекст Order
+// Мидлвара, сохраняющая в контекст Order по id
 func (a *Api) orderCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		orderIDstr := chi.URLParam(r, "orderID")
 		orderID, err := strconv.ParseInt(orderIDstr, 10, 64)
 		if err != nil {
 			log.Printf("%v: ошибка конвертации %s в число: %v\n", a.name, orderIDstr, err)
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			a.writeError(w, r, http.StatusBadRequest, "invalid_order_id", "order id must be an integer")
 			return
 		}
 
-		log.Printf("%v: запрос OrderOut из кеша/бд, OrderID: %v\n", a.name, orderIDstr)
-		orderOut, err := a.csh.GetOrderOutById(orderID)
-		if err != nil {
-			log.Printf("%v: ошибка получения OrderOut из базы данных: %v\n", a.name, err)
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound) // 404
+		log.Printf("%v: запрос Order из кеша/бд, OrderID: %v\n", a.name, orderIDstr)
+		order, err := a.csh.GetOrderById(orderID)
+		a.serveOrder(w, r, next, order, err)
+	})
+}
+
+// Мидлвара, сохраняющая в контекст Order по OrderUID
+func (a *Api) orderByUIDCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderUID := chi.URLParam(r, "orderUID")
+		log.Printf("%v: запрос Order из кеша/бд, OrderUID: %v\n", a.name, orderUID)
+		order, err := a.csh.GetOrderByUID(orderUID)
+		a.serveOrder(w, r, next, order, err)
+	})
+}
+
+// Мидлвара, сохраняющая в контекст Order по TrackNumber
+func (a *Api) orderByTrackCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trackNumber := chi.URLParam(r, "trackNumber")
+		log.Printf("%v: запрос Order из кеша/бд, TrackNumber: %v\n", a.name, trackNumber)
+		order, err := a.csh.GetOrderByTrackNumber(trackNumber)
+		a.serveOrder(w, r, next, order, err)
+	})
+}
+
+// Передача найденного Order следующему обработчику через контекст либо отправка ошибки
+func (a *Api) serveOrder(w http.ResponseWriter, r *http.Request, next http.Handler, order *db.Order, err error) {
+	if err != nil {
+		log.Printf("%v: ошибка получения Order из базы данных: %v\n", a.name, err)
+		if errors.Is(err, db.ErrOrderNotFound) {
+			a.writeError(w, r, http.StatusNotFound, "order_not_found", err.Error()) // 404
 			return
 		}
-		ctx := context.WithValue(r.Context(), orderKey, orderOut)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+		a.writeError(w, r, http.StatusInternalServerError, "internal_error", "unable to get order") // 500
+		return
+	}
+	ctx := context.WithValue(r.Context(), orderKey, order)
+	next.ServeHTTP(w, r.WithContext(ctx))
 }
 
 // Обработчик главной страницы http://localhost:3333
@@ -125,13 +194,18 @@ func (a *Api) WellcomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Хендлер запроса Order
+// Хендлер запроса Order: HTML-страница с OrderOut или полный Order в JSON
 func (a *Api) GetOrder(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	orderOut, ok := ctx.Value(orderKey).(*db.OrderOut)
+	order, ok := ctx.Value(orderKey).(*db.Order)
 	if !ok {
-		log.Printf("%v: getOrder(): ошибка приведения интерфейса к типу *OrderOut\n", a.name)
-		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity) // 422
+		log.Printf("%v: getOrder(): ошибка приведения интерфейса к типу *Order\n", a.name)
+		a.writeError(w, r, http.StatusUnprocessableEntity, "unprocessable_entity", "order is missing in request context") // 422
+		return
+	}
+
+	if wantsJSON(r) {
+		a.writeJSON(w, http.StatusOK, order)
 		return
 	}
 
@@ -143,10 +217,41 @@ func (a *Api) GetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	out := db.NewOrderOut(order)
+	history, err := a.csh.GetOrderHistory(order.OrderUID)
+	if err != nil {
+		// страница отображается и без истории статусов
+		log.Printf("%v: GetOrder(): ошибка получения истории статусов Order: %v\n", a.name, err)
+	} else {
+		out.Status, out.History = history.Status, history.Events
+	}
+
 	w.WriteHeader(http.StatusOK)
-	t.ExecuteTemplate(w, "order.html", orderOut)
+	err = t.ExecuteTemplate(w, "order.html", out)
 	if err != nil {
 		log.Printf("%v: GetOrder(): ошибка выполнения шаблона html: %s\n", a.name, err)
 		return
 	}
 }
+
+// Хендлер истории статусов Order (JSON): текущий статус и события от старых к новым
+func (a *Api) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	order, ok := r.Context().Value(orderKey).(*db.Order)
+	if !ok {
+		log.Printf("%v: GetOrderHistory(): ошибка приведения интерфейса к типу *Order\n", a.name)
+		a.writeError(w, r, http.StatusUnprocessableEntity, "unprocessable_entity", "order is missing in request context") // 422
+		return
+	}
+
+	history, err := a.csh.GetOrderHistory(order.OrderUID)
+	if err != nil {
+		log.Printf("%v: ошибка получения истории статусов Order: %v\n", a.name, err)
+		if errors.Is(err, db.ErrOrderNotFound) {
+			a.writeError(w, r, http.StatusNotFound, "order_not_found", err.Error()) // 404
+			return
+		}
+		a.writeError(w, r, http.StatusInternalServerError, "internal_error", "unable to get order history") // 500
+		return
+	}
+	a.writeJSON(w, http.StatusOK, history)
+}
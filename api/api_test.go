@@ -0,0 +1,326 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+)
+
+// Api поверх MemoryStore и кеша. http-сервер слушает случайный порт, запросы выполняются напрямую через роутер
+func newTestApi(t *testing.T, checks []HealthCheck) (*Api, *db.MemoryStore) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	csh := testutil.NewCache(t, store, 10)
+	a := NewApi(config.HTTPConfig{Addr: "127.0.0.1:0"}, csh, checks)
+	t.Cleanup(func() { a.Finish(context.Background()) })
+	return a, store
+}
+
+func doRequest(t *testing.T, a *Api, method, target, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	a.rtr.ServeHTTP(w, r)
+	if out != nil {
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Fatalf("%s %s: Content-Type = %q, want JSON", method, target, ct)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, target, w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+func TestGetOrder(t *testing.T) {
+	a, _ := newTestApi(t, nil)
+	oid := testutil.AddOrders(t, a.csh, "order-1")["order-1"]
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string // код ошибки, пусто - ответ с Order
+	}{
+		{name: "by id", target: fmt.Sprintf("/api/v1/orders/%d", oid), status: http.StatusOK},
+		{name: "by uid", target: "/api/v1/orders/uid/order-1", status: http.StatusOK},
+		{name: "by track number", target: "/api/v1/orders/track/TRACK-order-1", status: http.StatusOK},
+		{name: "json via accept header", target: fmt.Sprintf("/orders/%d", oid), status: http.StatusOK},
+		{name: "unknown id", target: "/api/v1/orders/999", status: http.StatusNotFound, code: "order_not_found"},
+		{name: "unknown uid", target: "/api/v1/orders/uid/missing", status: http.StatusNotFound, code: "order_not_found"},
+		{name: "invalid id", target: "/api/v1/orders/abc", status: http.StatusBadRequest, code: "invalid_order_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			a.rtr.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+
+			if tt.code != "" {
+				var resp errorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("invalid error response %q: %v", w.Body.String(), err)
+				}
+				if resp.Error.Code != tt.code || resp.Error.Status != tt.status {
+					t.Errorf("error = %+v, want code %s", resp.Error, tt.code)
+				}
+				return
+			}
+			var o db.Order
+			if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil {
+				t.Fatalf("invalid order response %q: %v", w.Body.String(), err)
+			}
+			if o.OrderUID != "order-1" || o.Payment.Amount != 1817 || len(o.Items) != 1 {
+				t.Errorf("order = %+v", o)
+			}
+		})
+	}
+}
+
+func TestListOrdersPaging(t *testing.T) {
+	a, _ := newTestApi(t, nil)
+	oids := make([]int64, 0, 5)
+	for i := 1; i <= 5; i++ {
+		uid := fmt.Sprintf("order-%d", i)
+		oids = append(oids, testutil.AddOrders(t, a.csh, uid)[uid])
+	}
+
+	// от новых к старым, по 2 на странице
+	want := [][]int64{{oids[4], oids[3]}, {oids[2], oids[1]}, {oids[0]}}
+	target := "/api/v1/orders?limit=2"
+	for i, page := range want {
+		var resp orderListResponse
+		if w := doRequest(t, a, http.MethodGet, target, "", &resp); w.Code != http.StatusOK {
+			t.Fatalf("page %d: status = %d, want 200", i, w.Code)
+		}
+		got := make([]int64, 0, len(resp.Orders))
+		for _, item := range resp.Orders {
+			got = append(got, item.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(page) {
+			t.Fatalf("page %d: ids = %v, want %v", i, got, page)
+		}
+		if last := i == len(want)-1; last != (resp.NextCursor == "") {
+			t.Fatalf("page %d: next_cursor = %q", i, resp.NextCursor)
+		}
+		target = "/api/v1/orders?limit=2&cursor=" + resp.NextCursor
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		orders int
+	}{
+		{name: "default limit", target: "/api/v1/orders", status: http.StatusOK, orders: 5},
+		{name: "filter", target: "/api/v1/orders?customer_id=nobody", status: http.StatusOK, orders: 0},
+		{name: "limit too large", target: "/api/v1/orders?limit=1000", status: http.StatusBadRequest},
+		{name: "invalid cursor", target: "/api/v1/orders?cursor=***", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp orderListResponse
+			var out interface{} = &resp
+			if tt.status != http.StatusOK {
+				out = &errorResponse{}
+			}
+			if w := doRequest(t, a, http.MethodGet, tt.target, "", out); w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if len(resp.Orders) != tt.orders {
+				t.Errorf("got %d orders, want %d", len(resp.Orders), tt.orders)
+			}
+		})
+	}
+}
+
+func TestUpdateOrder(t *testing.T) {
+	orderJSON := func(mutate func(o *db.Order)) string {
+		o := testutil.Order("order-1")
+		mutate(&o)
+		data, _ := json.Marshal(o)
+		return string(data)
+	}
+
+	tests := []struct {
+		name   string
+		id     string // пусто - id сохраненного Order
+		body   string
+		status int
+		code   string
+		track  string // TrackNumber после запроса
+	}{
+		{
+			name:   "updated",
+			body:   orderJSON(func(o *db.Order) { o.TrackNumber = "TRACK-new" }),
+			status: http.StatusOK,
+			track:  "TRACK-new",
+		},
+		{
+			name:   "order_uid is taken from stored order",
+			body:   orderJSON(func(o *db.Order) { o.OrderUID, o.TrackNumber = "", "TRACK-new" }),
+			status: http.StatusOK,
+			track:  "TRACK-new",
+		},
+		{
+			name:   "order_uid mismatch",
+			body:   orderJSON(func(o *db.Order) { o.OrderUID = "order-2" }),
+			status: http.StatusConflict,
+			code:   "order_uid_mismatch",
+			track:  "TRACK-order-1",
+		},
+		{
+			name:   "invalid order",
+			body:   orderJSON(func(o *db.Order) { o.Payment.Amount = 1 }),
+			status: http.StatusUnprocessableEntity,
+			code:   "invalid_order",
+			track:  "TRACK-order-1",
+		},
+		{
+			name:   "unknown field",
+			body:   `{"order_uid":"order-1","gift_wrap":true}`,
+			status: http.StatusBadRequest,
+			code:   "invalid_json",
+			track:  "TRACK-order-1",
+		},
+		{
+			name:   "unknown id",
+			id:     "999",
+			body:   orderJSON(func(o *db.Order) {}),
+			status: http.StatusNotFound,
+			code:   "order_not_found",
+			track:  "TRACK-order-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, store := newTestApi(t, nil)
+			oid := testutil.AddOrders(t, a.csh, "order-1")["order-1"]
+			id := tt.id
+			if id == "" {
+				id = fmt.Sprint(oid)
+			}
+
+			var resp errorResponse
+			w := doRequest(t, a, http.MethodPut, "/api/v1/orders/"+id, tt.body, &resp)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if resp.Error.Code != tt.code {
+				t.Errorf("error code = %q, want %q", resp.Error.Code, tt.code)
+			}
+			if tt.code == "invalid_order" && (len(resp.Error.Fields) != 1 || resp.Error.Fields[0].Field != "payment.amount") {
+				t.Errorf("field errors = %+v, want payment.amount", resp.Error.Fields)
+			}
+
+			// изменение видно и через кеш, и в хранилище
+			var cached db.Order
+			doRequest(t, a, http.MethodGet, fmt.Sprintf("/api/v1/orders/%d", oid), "", &cached)
+			stored, err := store.GetOrderByID(oid)
+			if err != nil {
+				t.Fatalf("GetOrderByID() error: %v", err)
+			}
+			if cached.TrackNumber != tt.track || stored.TrackNumber != tt.track {
+				t.Errorf("track number: cached %q, stored %q, want %q", cached.TrackNumber, stored.TrackNumber, tt.track)
+			}
+		})
+	}
+}
+
+func TestUpdateOrderOnlyInJSONAPI(t *testing.T) {
+	a, store := newTestApi(t, nil)
+	oid := testutil.AddOrders(t, a.csh, "order-1")["order-1"]
+
+	o := testutil.Order("order-1")
+	o.TrackNumber = "TRACK-new"
+	body, _ := json.Marshal(o)
+	// HTML-маршрут только для чтения
+	w := doRequest(t, a, http.MethodPut, fmt.Sprintf("/orders/%d", oid), string(body), nil)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405: %s", w.Code, w.Body.String())
+	}
+	if stored, err := store.GetOrderByID(oid); err != nil || stored.TrackNumber != "TRACK-order-1" {
+		t.Errorf("stored order = %+v, %v", stored, err)
+	}
+}
+
+func TestUpdateOrderPendingInvalidation(t *testing.T) {
+	a, store := newTestApi(t, nil)
+	oid := testutil.AddOrders(t, a.csh, "order-1")["order-1"]
+	a.csh.SetInvalidationBroadcaster(func(int64) error { return errors.New("nats: connection closed") })
+
+	o := testutil.Order("order-1")
+	o.TrackNumber = "TRACK-new"
+	body, _ := json.Marshal(o)
+	var resp pendingUpdateResponse
+	w := doRequest(t, a, http.MethodPut, fmt.Sprintf("/api/v1/orders/%d", oid), string(body), &resp)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body.String())
+	}
+	if resp.Propagation != "pending" || resp.Order.TrackNumber != "TRACK-new" {
+		t.Errorf("response = %+v", resp)
+	}
+	// изменение сохранено, несмотря на недоставленное уведомление
+	if stored, err := store.GetOrderByID(oid); err != nil || stored.TrackNumber != "TRACK-new" {
+		t.Errorf("stored order = %+v, %v", stored, err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name   string
+		target string
+		checks []HealthCheck
+		status int
+		resp   healthResponse
+	}{
+		{
+			name:   "liveness",
+			target: "/healthz",
+			checks: []HealthCheck{{Name: "storage", Check: down}},
+			status: http.StatusOK,
+			resp:   healthResponse{Status: "ok"},
+		},
+		{
+			name:   "ready",
+			target: "/readyz",
+			checks: []HealthCheck{{Name: "storage", Check: ok}, {Name: "nats", Check: ok}},
+			status: http.StatusOK,
+			resp:   healthResponse{Status: "ready", Checks: map[string]string{"storage": "ok", "nats": "ok"}},
+		},
+		{
+			name:   "not ready",
+			target: "/readyz",
+			checks: []HealthCheck{{Name: "storage", Check: ok}, {Name: "nats", Check: down}},
+			status: http.StatusServiceUnavailable,
+			resp:   healthResponse{Status: "not_ready", Checks: map[string]string{"storage": "ok", "nats": "connection refused"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestApi(t, tt.checks)
+			var resp healthResponse
+			if w := doRequest(t, a, http.MethodGet, tt.target, "", &resp); w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if fmt.Sprint(resp) != fmt.Sprint(tt.resp) {
+				t.Errorf("response = %+v, want %+v", resp, tt.resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Таймаут выполнения всех проверок готовности
+const readinessTimeout = 3 * time.Second
+
+// Проверка готовности компонента сервиса (Postgres, NATS, кеш)
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Liveness: процесс запущен и обрабатывает http-запросы
+func (a *Api) Healthz(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Readiness: все компоненты доступны, сервис может принимать трафик
+func (a *Api) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	resp := healthResponse{Status: "ready", Checks: make(map[string]string, len(a.checks))}
+	status := http.StatusOK
+	for _, hc := range a.checks {
+		if err := hc.Check(ctx); err != nil {
+			resp.Checks[hc.Name] = err.Error()
+			resp.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[hc.Name] = "ok"
+	}
+	a.writeJSON(w, status, resp)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"wb-test-task/internal/validation"
+)
+
+type jsonkey string
+
+const forceJSONKey jsonkey = "forceJSON"
+
+// Тело ответа с ошибкой для JSON API
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int                     `json:"status"`
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Fields  []validation.FieldError `json:"fields,omitempty"` // ошибки по полям для invalid_order
+}
+
+// Мидлвара для маршрутов /api/v1: ответы (в том числе ошибки) всегда в JSON
+func forceJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), forceJSONKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Проверка, ожидает ли клиент ответ в JSON (маршрут /api/v1 или заголовок Accept: application/json)
+func wantsJSON(r *http.Request) bool {
+	if force, ok := r.Context().Value(forceJSONKey).(bool); ok && force {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// Отправка ответа в JSON
+func (a *Api) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("%v: writeJSON(): ошибка кодирования ответа: %v\n", a.name, err)
+	}
+}
+
+// Отправка ошибки: структурированное тело для JSON-клиентов, текст статуса - для браузера
+func (a *Api) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if !wantsJSON(r) {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	a.writeJSON(w, status, errorResponse{Error: apiError{Status: status, Code: code, Message: message}})
+}
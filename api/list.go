@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"wb-test-task/internal/db"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Ответ JSON API со страницей списка Order
+type orderListResponse struct {
+	Orders     []db.OrderListItem `json:"orders"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// Данные для шаблона orders.html
+type orderListPage struct {
+	Orders  []db.OrderListItem
+	Query   url.Values // текущие фильтры - для формы
+	NextURL string
+}
+
+// Хендлер списка Order с фильтрами и курсорной пагинацией: GET /orders?brand=Nike&limit=20&cursor=...
+func (a *Api) ListOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseOrderFilter(query)
+	if err != nil {
+		log.Printf("%v: ListOrders(): некорректные параметры запроса: %v\n", a.name, err)
+		a.writeError(w, r, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	page, err := a.csh.ListOrders(filter)
+	if err != nil {
+		log.Printf("%v: ListOrders(): ошибка получения списка Order: %v\n", a.name, err)
+		a.writeError(w, r, http.StatusInternalServerError, "internal_error", "unable to list orders")
+		return
+	}
+
+	var nextCursor string
+	if page.NextID > 0 {
+		nextCursor = encodeCursor(page.NextID)
+	}
+
+	if wantsJSON(r) {
+		a.writeJSON(w, http.StatusOK, orderListResponse{Orders: page.Orders, NextCursor: nextCursor})
+		return
+	}
+
+	data := orderListPage{Orders: page.Orders, Query: query}
+	if nextCursor != "" {
+		next := url.Values{}
+		for k, v := range query {
+			next[k] = v
+		}
+		next.Set("cursor", nextCursor)
+		data.NextURL = "/orders?" + next.Encode()
+	}
+
+	t, err := template.New("orders.html").Funcs(template.FuncMap{
+		"totalPrice": func(o db.Order) int { return o.GetTotalPrice() },
+	}).ParseFiles("ui/templates/orders.html")
+	if err != nil {
+		log.Printf("%v: ListOrders(): ошибка парсинга шаблона html: %s\n", a.name, err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = t.ExecuteTemplate(w, "orders.html", data)
+	if err != nil {
+		log.Printf("%v: ListOrders(): ошибка выполнения шаблона html: %s\n", a.name, err)
+		return
+	}
+}
+
+// Разбор параметров запроса в фильтр списка Order
+func parseOrderFilter(query url.Values) (db.OrderFilter, error) {
+	filter := db.OrderFilter{
+		CustomerID:      query.Get("customer_id"),
+		DeliveryService: query.Get("delivery_service"),
+		Locale:          query.Get("locale"),
+		Currency:        query.Get("currency"),
+		Provider:        query.Get("provider"),
+		Bank:            query.Get("bank"),
+		Brand:           query.Get("brand"),
+		Limit:           defaultPageSize,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return filter, errors.New("limit must be an integer between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		beforeID, err := decodeCursor(v)
+		if err != nil {
+			return filter, errors.New("cursor is invalid")
+		}
+		filter.BeforeID = beforeID
+	}
+	for name, dst := range map[string]**int{"payment_dt_from": &filter.PaymentDtFrom, "payment_dt_to": &filter.PaymentDtTo} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return filter, errors.New(name + " must be an integer")
+			}
+			*dst = &n
+		}
+	}
+	return filter, nil
+}
+
+// Курсор страницы - id последнего Order в непрозрачном для клиента виде
+func encodeCursor(oid int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(oid, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	oid, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || oid < 1 {
+		return 0, errors.New("invalid cursor")
+	}
+	return oid, nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Максимальный размер тела запроса обновления Order
+const maxOrderBodySize = 1 << 20
+
+// Ответ на обновление Order, уведомление об изменении которого еще не доставлено остальным репликам
+type pendingUpdateResponse struct {
+	Order       db.Order `json:"order"`
+	Propagation string   `json:"propagation"` // pending
+	Message     string   `json:"message"`
+}
+
+// Хендлер обновления Order: PUT /api/v1/orders/123 с полным Order в теле. Сохраненный Order передается
+// мидлварой orderCtx; order_uid изменить нельзя (если он не указан - берется сохраненный)
+func (a *Api) UpdateOrder(w http.ResponseWriter, r *http.Request) {
+	stored, ok := r.Context().Value(orderKey).(*db.Order)
+	if !ok {
+		log.Printf("%v: UpdateOrder(): ошибка приведения интерфейса к типу *Order\n", a.name)
+		a.writeError(w, r, http.StatusUnprocessableEntity, "unprocessable_entity", "order is missing in request context") // 422
+		return
+	}
+	oid, _ := strconv.ParseInt(chi.URLParam(r, "orderID"), 10, 64) // проверено в orderCtx
+
+	var order db.Order
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOrderBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&order); err != nil {
+		log.Printf("%v: UpdateOrder(): некорректное тело запроса: %v\n", a.name, err)
+		a.writeError(w, r, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if order.OrderUID == "" {
+		order.OrderUID = stored.OrderUID
+	}
+
+	if err := validation.ValidateOrder(&order); err != nil {
+		var fieldErrs validation.Errors
+		if errors.As(err, &fieldErrs) && wantsJSON(r) {
+			a.writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: apiError{Status: http.StatusUnprocessableEntity,
+				Code: "invalid_order", Message: "order is invalid", Fields: fieldErrs}})
+			return
+		}
+		a.writeError(w, r, http.StatusUnprocessableEntity, "invalid_order", err.Error())
+		return
+	}
+
+	err := a.csh.UpdateOrder(oid, order)
+	switch {
+	case errors.Is(err, db.ErrOrderNotFound):
+		a.writeError(w, r, http.StatusNotFound, "order_not_found", err.Error())
+		return
+	case errors.Is(err, db.ErrOrderUIDMismatch):
+		a.writeError(w, r, http.StatusConflict, "order_uid_mismatch", err.Error())
+		return
+	case errors.Is(err, db.ErrInvalidationPending):
+		// Order сохранен, но другие реплики могут отдавать прежнюю версию, пока уведомление не доставлено
+		log.Printf("%v: UpdateOrder(): Order (id:%d) updated, %v\n", a.name, oid, err)
+		a.writeJSON(w, http.StatusAccepted, pendingUpdateResponse{Order: order, Propagation: "pending",
+			Message: "order is updated, other replicas may serve the previous version until the invalidation is delivered"})
+		return
+	case err != nil:
+		log.Printf("%v: UpdateOrder(): ошибка обновления Order (id:%d): %v\n", a.name, oid, err)
+		a.writeError(w, r, http.StatusInternalServerError, "internal_error", "unable to update order")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, order)
+}
@@ -1,40 +1,74 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"log"
 	"os"
-	"os/signal"
+	"time"
 	"wb-test-task/api"
-	"wb-test-task/cmd/config"
+	"wb-test-task/internal/config"
 	"wb-test-task/internal/db"
+	"wb-test-task/internal/lifecycle"
 	"wb-test-task/internal/streaming"
 )
 
 func main() {
 
-	// Инициализация конфигурации проекта
-	config.ConfigSetup()
-	dbObject := db.NewDB()
-	csh := db.NewCache(dbObject)
-	sh := streaming.NewStreamingHandler(dbObject)
+	// Инициализация конфигурации проекта: файл config.toml, переменные окружения, флаги
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("config: %v\n", err)
+	}
+	// Подкоманда migrate up|down [N]|status: управление схемой БД без запуска сервиса
+	if len(cfg.Args) > 0 && cfg.Args[0] == "migrate" {
+		if cfg.DB.Storage != config.StoragePostgres {
+			log.Fatalf("migrate: migrations are supported only for postgres storage\n")
+		}
+		if err := db.NewDB(cfg.DB).RunMigrateCommand(context.Background(), cfg.Args[1:]); err != nil {
+			log.Fatalf("migrate: %v\n", err)
+		}
+		return
+	}
 
-	// Запуск сервера для выдачи OrderOut по адресу http://localhost:3333/orders/123
-	myApi := api.NewApi(csh)
+	var dbObject db.OrderStore
+	if cfg.DB.Storage == config.StorageMemory {
+		dbObject = db.NewMemoryStore()
+	} else {
+		pg := db.NewDB(cfg.DB)
+		// Применение миграций схемы: без актуальной схемы сервис не запускается
+		if cfg.DB.AutoMigrate {
+			if _, err := pg.MigrateUp(context.Background()); err != nil {
+				log.Fatalf("migrate: %v\n", err)
+			}
+		}
+		dbObject = pg
+	}
+	csh := db.NewCache(cfg.Cache, dbObject)
+	sh := streaming.NewStreamingHandler(cfg.NATS, dbObject, csh)
+	// Обновление Order на этой реплике рассылает инвалидацию кеша остальным
+	csh.SetInvalidationBroadcaster(sh.BroadcastInvalidation)
 
-	// Wait for a SIGINT (perhaps triggered by user with CTRL-C)
-	// Run cleanup when signal is received
-	signalChan := make(chan os.Signal, 1)
-	cleanupDone := make(chan bool)
-	signal.Notify(signalChan, os.Interrupt)
-	go func() {
-		for range signalChan {
-			fmt.Printf("\nReceived an interrupt, unsubscribing and closing connection...\n\n")
-			csh.Finish()
-			sh.Finish()
-			myApi.Finish()
+	// Запуск сервера для выдачи OrderOut по адресу http://localhost:3333/orders/123
+	myApi := api.NewApi(cfg.HTTP, csh, []api.HealthCheck{
+		{Name: "storage", Check: dbObject.Ping},
+		{Name: "nats", Check: func(context.Context) error { return sh.Health() }},
+		{Name: "cache", Check: func(context.Context) error { return csh.Ready() }},
+	})
 
-			cleanupDone <- true
-		}
-	}()
-	<-cleanupDone
+	// Завершение работы по SIGTERM/SIGINT: прекращаем прием сообщений NATS и ждем обработки полученных,
+	// останавливаем http-сервер, очищаем кеш в БД и закрываем пул соединений - в этом порядке
+	lc := lifecycle.NewManager(time.Duration(cfg.Shutdown.TimeoutSeconds) * time.Second)
+	lc.Add("nats", sh.Finish)
+	lc.Add("http", myApi.Finish)
+	lc.Add("cache", func(context.Context) error {
+		csh.Finish()
+		return nil
+	})
+	lc.Add("storage", func(context.Context) error {
+		dbObject.Close()
+		return nil
+	})
+	if err := lc.Wait(); err != nil {
+		log.Fatalf("%v\n", err)
+	}
 }
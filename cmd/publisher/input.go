@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Чтение сообщений из файлов: JSON-массив, один JSON-объект или NDJSON (объекты подряд, по одному в строке).
+// Путь "-" - стандартный ввод. Каждое сообщение передается в handle в исходном виде
+func readMessages(ctx context.Context, paths []string, handle func(source string, data []byte) error) error {
+	for _, path := range paths {
+		if err := readFile(ctx, path, handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(ctx context.Context, path string, handle func(source string, data []byte) error) error {
+	if path == "-" {
+		return readStream(ctx, os.Stdin, "stdin", handle)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readStream(ctx, f, path, handle)
+}
+
+func readStream(ctx context.Context, r io.Reader, source string, handle func(source string, data []byte) error) error {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if first == '[' && !dec.More() {
+			if _, err := dec.Token(); err != nil {
+				return fmt.Errorf("%s: %w", source, err)
+			}
+			return nil
+		}
+		var msg json.RawMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF && first != '[' {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: message %d: %w", source, n, err)
+		}
+		if err := handle(fmt.Sprintf("%s: message %d", source, n), bytes.TrimSpace(msg)); err != nil {
+			return err
+		}
+	}
+}
+
+// Первый непробельный символ потока (не извлекается из r)
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/streaming"
+	"wb-test-task/internal/validation"
+
+	stan "github.com/nats-io/stan.go"
+)
+
+// Виды сообщений
+const (
+	kindOrder  = "order"  // Order в NATS_SUBJECT
+	kindStatus = "status" // события смены статуса в NATS_STATUS_SUBJECT
+)
+
+// Публикация Order или событий смены статуса из файлов JSON/NDJSON (или stdin) в NATS Streaming:
+//
+//	go run ./cmd/publisher -rate 100 -concurrency 8 orders.ndjson
+//	cat events.json | go run ./cmd/publisher -kind status
+func main() {
+	fs := flag.NewFlagSet("publisher", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: publisher [flags] [file ...] (no files or \"-\" - read stdin)\n")
+		fs.PrintDefaults()
+	}
+	kind := fs.String("kind", kindOrder, "messages in input: order or status")
+	subject := fs.String("subject", "", "subject to publish to (default NATS_SUBJECT or NATS_STATUS_SUBJECT by -kind)")
+	clientID := fs.String("client-id", fmt.Sprintf("publisher-%d", os.Getpid()), "NATS Streaming client id")
+	rate := fs.Float64("rate", 0, "messages per second (0 - unlimited)")
+	concurrency := fs.Int("concurrency", 1, "messages awaiting ack at the same time")
+	async := fs.Bool("async", false, "publish asynchronously, handling acks in a callback")
+	ackTimeout := fs.Duration("ack-timeout", 30*time.Second, "how long to wait for an ack")
+	validate := fs.Bool("validate", true, "check messages before publishing, invalid ones are skipped")
+
+	cfg, err := config.LoadNATS(fs, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("config: %v\n", err)
+	}
+	if *concurrency < 1 || *rate < 0 || *ackTimeout <= 0 {
+		log.Fatalf("config: -concurrency and -ack-timeout must be positive, -rate must not be negative\n")
+	}
+
+	var check func(data []byte) error
+	switch *kind {
+	case kindOrder:
+		if *subject == "" {
+			*subject = cfg.NATS.Subject
+		}
+		check = checkOrder
+	case kindStatus:
+		if *subject == "" {
+			*subject = cfg.NATS.StatusSubject
+		}
+		check = checkStatusEvent
+	default:
+		log.Fatalf("config: -kind must be order or status\n")
+	}
+	if !*validate {
+		check = func([]byte) error { return nil }
+	}
+
+	paths := cfg.Args
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	sc, err := stan.Connect(cfg.NATS.ClusterID, *clientID, stan.NatsURL(cfg.NATS.Hosts),
+		stan.PubAckWait(*ackTimeout), stan.MaxPubAcksInflight(*concurrency))
+	if err != nil {
+		log.Fatalf("publisher: can't connect to %s: %v\n", cfg.NATS.Hosts, err)
+	}
+	defer sc.Close()
+
+	// SIGINT/SIGTERM: чтение прекращается, отправленные сообщения дожидаются подтверждения
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	msgs := make(chan []byte, *concurrency)
+	skipped := 0
+	readDone := make(chan error, 1)
+	go func() {
+		defer close(msgs)
+		readDone <- readMessages(ctx, paths, func(source string, data []byte) error {
+			if err := check(data); err != nil {
+				log.Printf("publisher: %s skipped: %v\n", source, err)
+				skipped++
+				return nil
+			}
+			select {
+			case msgs <- data:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	log.Printf("publisher: publishing to %s (rate %v/s, concurrency %d, async %v)\n", *subject, *rate, *concurrency, *async)
+	pub := streaming.NewPublisher(&sc, streaming.PublisherOptions{
+		Subject:     *subject,
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		Async:       *async,
+	})
+	report := pub.Run(ctx, msgs)
+	readErr := <-readDone
+
+	printReport(report, skipped)
+	switch {
+	case errors.Is(readErr, context.Canceled):
+		log.Printf("publisher: interrupted, the rest of input is not published\n")
+	case readErr != nil:
+		log.Printf("publisher: input error: %v\n", readErr)
+	}
+	if readErr != nil || report.Failed > 0 || skipped > 0 {
+		sc.Close()
+		os.Exit(1)
+	}
+}
+
+// Проверка Order теми же правилами, что и в сервисе
+func checkOrder(data []byte) error {
+	var o db.Order
+	if err := json.Unmarshal(data, &o); err != nil {
+		return err
+	}
+	return validation.ValidateOrder(&o)
+}
+
+func checkStatusEvent(data []byte) error {
+	var e db.StatusEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	return e.Validate()
+}
+
+func printReport(r streaming.PublishReport, skipped int) {
+	fmt.Printf("published: %d, acked: %d, failed: %d, skipped (invalid): %d\n", r.Published, r.Acked, r.Failed, skipped)
+	if secs := r.Elapsed.Seconds(); secs > 0 {
+		fmt.Printf("elapsed: %v, throughput: %.1f msg/s\n", r.Elapsed.Round(time.Millisecond), float64(r.Published)/secs)
+	}
+	if len(r.Latencies) > 0 {
+		fmt.Printf("ack latency: p50 %v, p90 %v, p99 %v, max %v\n", r.Percentile(50), r.Percentile(90),
+			r.Percentile(99), r.Percentile(100))
+	}
+	errs := make([]string, 0, len(r.Errors))
+	for e := range r.Errors {
+		errs = append(errs, e)
+	}
+	sort.Strings(errs)
+	for _, e := range errs {
+		fmt.Printf("  %d x %s\n", r.Errors[e], e)
+	}
+}
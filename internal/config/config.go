@@ -0,0 +1,399 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Файл конфигурации по умолчанию (если отсутствует - используются значения по умолчанию)
+const DefaultConfigFile = "cmd/config/config.toml"
+
+// Хранилища Order
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory" // данные в памяти процесса: для разработки без Postgres и тестов
+)
+
+// Политики разбора сообщений с Order
+const (
+	DecodeLenient = "lenient" // неизвестные и отсутствующие поля игнорируются
+	DecodeWarn    = "warn"    // расхождения со схемой пишутся в лог и метрики, сообщение обрабатывается
+	DecodeStrict  = "strict"  // сообщение с расхождениями отправляется в dead letter
+)
+
+// Политики сохранения кеша при корректном завершении работы
+const (
+	CacheShutdownWipe     = "wipe"     // список заказов кеша в БД удаляется: следующий запуск начнется с пустым кешем
+	CacheShutdownKeep     = "keep"     // список остается как есть (в порядке добавления заказов в кеш)
+	CacheShutdownSnapshot = "snapshot" // список заменяется N наиболее востребованными заказами кеша
+)
+
+// Настройки подключения к Postgres
+type DBConfig struct {
+	Storage                 string `toml:"storage"` // postgres или memory
+	Username                string `toml:"username"`
+	Password                string `toml:"password"`
+	Host                    string `toml:"host"`
+	Name                    string `toml:"name"`
+	PoolMaxConns            int    `toml:"pool_max_conns"`
+	PoolMaxConnLifetimeSecs int    `toml:"pool_max_conn_lifetime_seconds"`
+	AutoMigrate             bool   `toml:"auto_migrate"` // применять миграции схемы при старте
+}
+
+// Настройки NATS-Streaming
+type NATSConfig struct {
+	Hosts          string `toml:"hosts"`
+	ClusterID      string `toml:"cluster_id"`
+	ClientID       string `toml:"client_id"`
+	Subject        string `toml:"subject"`
+	DurableName    string `toml:"durable_name"`
+	QueueGroup     string `toml:"queue_group"` // группа подписчиков: реплики с одной группой делят сообщения между собой
+	AckWaitSeconds int    `toml:"ack_wait_seconds"`
+	DLQSubject     string `toml:"dlq_subject"`
+	MaxRedelivery  int    `toml:"max_redelivery"`
+	Embedded       bool   `toml:"embedded"`      // запуск встроенного NATS Streaming сервера вместо подключения к Hosts
+	DecodePolicy   string `toml:"decode_policy"` // lenient, warn или strict
+	// subject NATS (без Streaming) для рассылки инвалидаций кеша между репликами
+	InvalidationSubject string `toml:"invalidation_subject"`
+	// subject NATS Streaming с событиями смены статуса заказов
+	StatusSubject string `toml:"status_subject"`
+}
+
+// Настройки кеша
+type CacheConfig struct {
+	Size           int    `toml:"size"`
+	TTLSeconds     int    `toml:"ttl_seconds"`
+	AppKey         string `toml:"app_key"`
+	ShutdownPolicy string `toml:"shutdown_policy"` // wipe, keep или snapshot
+	SnapshotSize   int    `toml:"snapshot_size"`   // число заказов для snapshot (0 - весь кеш)
+	// Локальный файл со снимком кеша для быстрого старта (пусто - не используется)
+	SnapshotFile            string `toml:"snapshot_file"`
+	SnapshotIntervalSeconds int    `toml:"snapshot_interval_seconds"`
+}
+
+// Настройки http-сервера
+type HTTPConfig struct {
+	Addr string `toml:"addr"`
+}
+
+// Настройки завершения работы
+type ShutdownConfig struct {
+	TimeoutSeconds int `toml:"timeout_seconds"` // общий срок на остановку всех компонентов
+}
+
+type Config struct {
+	DB       DBConfig       `toml:"db"`
+	NATS     NATSConfig     `toml:"nats"`
+	Cache    CacheConfig    `toml:"cache"`
+	HTTP     HTTPConfig     `toml:"http"`
+	Shutdown ShutdownConfig `toml:"shutdown"`
+
+	Args []string `toml:"-"` // аргументы командной строки после флагов (подкоманды, например migrate up)
+}
+
+// Значения по умолчанию
+func Default() Config {
+	return Config{
+		DB: DBConfig{
+			Storage:                 StoragePostgres,
+			PoolMaxConns:            5,
+			PoolMaxConnLifetimeSecs: 300,
+			AutoMigrate:             true,
+		},
+		NATS: NATSConfig{
+			DurableName:    "Replica-1",
+			AckWaitSeconds: 30,
+			MaxRedelivery:  5,
+			DecodePolicy:   DecodeWarn,
+		},
+		Cache: CacheConfig{
+			Size:                    10,
+			ShutdownPolicy:          CacheShutdownWipe,
+			SnapshotIntervalSeconds: 60,
+		},
+		HTTP: HTTPConfig{
+			Addr: ":3333",
+		},
+		Shutdown: ShutdownConfig{
+			TimeoutSeconds: 30,
+		},
+	}
+}
+
+// Параметр конфигурации: имя переменной окружения (из него же получается имя флага: DB_HOST -> -db-host)
+// и указатель на поле Config
+type setting struct {
+	env   string
+	value interface{} // *string, *int или *bool
+	usage string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"DB_STORAGE", &c.DB.Storage, "order storage: postgres or memory"},
+		{"DB_USERNAME", &c.DB.Username, "database user"},
+		{"DB_PASSWORD", &c.DB.Password, "database password"},
+		{"DB_HOST", &c.DB.Host, "database host[:port]"},
+		{"DB_NAME", &c.DB.Name, "database name"},
+		{"DB_POOL_MAXCONN", &c.DB.PoolMaxConns, "max connections in the database pool"},
+		{"DB_POOL_MAXCONN_LIFETIME", &c.DB.PoolMaxConnLifetimeSecs, "max lifetime of a pooled connection, seconds"},
+		{"DB_AUTO_MIGRATE", &c.DB.AutoMigrate, "apply schema migrations on startup"},
+
+		{"NATS_HOSTS", &c.NATS.Hosts, "NATS server URLs"},
+		{"NATS_CLUSTER_ID", &c.NATS.ClusterID, "NATS Streaming cluster id"},
+		{"NATS_CLIENT_ID", &c.NATS.ClientID, "NATS Streaming client id (unique per replica)"},
+		{"NATS_SUBJECT", &c.NATS.Subject, "subject with orders"},
+		{"NATS_DURABLE_NAME", &c.NATS.DurableName, "durable subscription name"},
+		{"NATS_QUEUE_GROUP", &c.NATS.QueueGroup, "durable queue group shared by replicas (empty - plain durable subscription)"},
+		{"NATS_ACK_WAIT_SECONDS", &c.NATS.AckWaitSeconds, "ack wait timeout, seconds"},
+		{"NATS_DLQ_SUBJECT", &c.NATS.DLQSubject, "dead letter subject (default <subject>.dlq)"},
+		{"NATS_MAX_REDELIVERY", &c.NATS.MaxRedelivery, "delivery attempts before a message goes to dead letter"},
+		{"NATS_EMBEDDED", &c.NATS.Embedded, "run an in-process NATS Streaming server (dev/test mode)"},
+		{"NATS_DECODE_POLICY", &c.NATS.DecodePolicy, "order message decoding: lenient, warn or strict"},
+		{"NATS_INVALIDATION_SUBJECT", &c.NATS.InvalidationSubject, "core NATS subject for cache invalidations (default <subject>.invalidate)"},
+		{"NATS_STATUS_SUBJECT", &c.NATS.StatusSubject, "subject with order status events (default <subject>.status)"},
+
+		{"CACHE_SIZE", &c.Cache.Size, "cache size, orders (0 - cache is off)"},
+		{"CACHE_TTL_SECONDS", &c.Cache.TTLSeconds, "cache entry TTL, seconds (0 - no TTL)"},
+		{"APP_KEY", &c.Cache.AppKey, "unique application name for the cache table"},
+		{"CACHE_SHUTDOWN_POLICY", &c.Cache.ShutdownPolicy, "cache table on graceful shutdown: wipe, keep or snapshot"},
+		{"CACHE_SNAPSHOT_SIZE", &c.Cache.SnapshotSize, "orders kept by the snapshot policy, hottest first (0 - whole cache)"},
+		{"CACHE_SNAPSHOT_FILE", &c.Cache.SnapshotFile, "local cache snapshot file for fast warm start (empty - off)"},
+		{"CACHE_SNAPSHOT_INTERVAL_SECONDS", &c.Cache.SnapshotIntervalSeconds, "how often the cache snapshot file is written, seconds"},
+
+		{"HTTP_ADDR", &c.HTTP.Addr, "http server address"},
+
+		{"SHUTDOWN_TIMEOUT_SECONDS", &c.Shutdown.TimeoutSeconds, "graceful shutdown deadline, seconds"},
+	}
+}
+
+func (s setting) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(s.env, "_", "-"))
+}
+
+func (s setting) set(raw string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", s.env, raw)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", s.env, raw)
+		}
+		*v = b
+	}
+	return nil
+}
+
+// Загрузка конфигурации. Приоритет (от низшего к высшему): значения по умолчанию, файл TOML,
+// переменные окружения, флаги командной строки
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("wb-test-task", flag.ContinueOnError)
+	cfg, err := load(fs, args, func(setting) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Параметры подключения к NATS Streaming и subjects для вспомогательных команд
+var natsClientSettings = map[string]bool{
+	"NATS_HOSTS":          true,
+	"NATS_CLUSTER_ID":     true,
+	"NATS_SUBJECT":        true,
+	"NATS_STATUS_SUBJECT": true,
+}
+
+// Загрузка настроек NATS для вспомогательных команд (cmd/publisher): тот же файл и переменные окружения,
+// флаги только для адреса, кластера и subjects. Собственные флаги команды регистрируются в fs до вызова
+func LoadNATS(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg, err := load(fs, args, func(s setting) bool { return natsClientSettings[s.env] })
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	if strings.TrimSpace(cfg.NATS.Hosts) == "" {
+		errs = append(errs, "NATS_HOSTS is required")
+	}
+	if strings.TrimSpace(cfg.NATS.ClusterID) == "" {
+		errs = append(errs, "NATS_CLUSTER_ID is required")
+	}
+	if strings.TrimSpace(cfg.NATS.Subject) == "" {
+		errs = append(errs, "NATS_SUBJECT is required")
+	}
+	if len(errs) > 0 {
+		return nil, errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return cfg, nil
+}
+
+// Загрузка значений параметров, для которых only возвращает true
+func load(fs *flag.FlagSet, args []string, only func(setting) bool) (*Config, error) {
+	cfg := Default()
+	var settings []setting
+	for _, s := range cfg.settings() {
+		if only(s) {
+			settings = append(settings, s)
+		}
+	}
+
+	configFile := fs.String("config", "", "path to TOML config file (env CONFIG_FILE, default "+DefaultConfigFile+")")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flagName()] = fs.String(s.flagName(), "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+
+	// Файл конфигурации
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path, required = DefaultConfigFile, false
+	}
+	if err := cfg.loadFile(path, required); err != nil {
+		return nil, err
+	}
+
+	// Переменные окружения
+	for _, s := range settings {
+		if raw, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(raw); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Флаги, явно указанные в командной строке
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if f.Name == s.flagName() && flagErr == nil {
+				flagErr = s.set(*flagValues[f.Name])
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if cfg.NATS.DLQSubject == "" {
+		cfg.NATS.DLQSubject = cfg.NATS.Subject + ".dlq"
+	}
+	if cfg.NATS.InvalidationSubject == "" {
+		cfg.NATS.InvalidationSubject = cfg.NATS.Subject + ".invalidate"
+	}
+	if cfg.NATS.StatusSubject == "" {
+		cfg.NATS.StatusSubject = cfg.NATS.Subject + ".status"
+	}
+	return &cfg, nil
+}
+
+// Чтение файла TOML поверх текущих значений
+func (c *Config) loadFile(path string, required bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if _, err := toml.Decode(string(data), c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Проверка конфигурации при старте
+func (c *Config) Validate() error {
+	var errs []string
+	required := map[string]string{
+		"NATS_CLUSTER_ID": c.NATS.ClusterID,
+		"NATS_CLIENT_ID":  c.NATS.ClientID,
+		"NATS_SUBJECT":    c.NATS.Subject,
+		"APP_KEY":         c.Cache.AppKey,
+		"HTTP_ADDR":       c.HTTP.Addr,
+	}
+	for _, s := range c.settings() {
+		if v, ok := required[s.env]; ok && strings.TrimSpace(v) == "" {
+			errs = append(errs, s.env+" is required")
+		}
+	}
+	switch c.DB.Storage {
+	case StoragePostgres:
+		if c.DB.Username == "" || c.DB.Host == "" || c.DB.Name == "" {
+			errs = append(errs, "DB_USERNAME, DB_HOST and DB_NAME are required for postgres storage")
+		}
+	case StorageMemory:
+	default:
+		errs = append(errs, "DB_STORAGE must be postgres or memory")
+	}
+	switch c.NATS.DecodePolicy {
+	case DecodeLenient, DecodeWarn, DecodeStrict:
+	default:
+		errs = append(errs, "NATS_DECODE_POLICY must be lenient, warn or strict")
+	}
+	if !c.NATS.Embedded && strings.TrimSpace(c.NATS.Hosts) == "" {
+		errs = append(errs, "NATS_HOSTS is required unless NATS_EMBEDDED is set")
+	}
+	if c.NATS.StatusSubject == c.NATS.Subject || c.NATS.StatusSubject == c.NATS.DLQSubject {
+		errs = append(errs, "NATS_STATUS_SUBJECT must differ from NATS_SUBJECT and NATS_DLQ_SUBJECT")
+	}
+	if c.DB.PoolMaxConns < 1 {
+		errs = append(errs, "DB_POOL_MAXCONN must be positive")
+	}
+	if c.DB.PoolMaxConnLifetimeSecs < 0 {
+		errs = append(errs, "DB_POOL_MAXCONN_LIFETIME must not be negative")
+	}
+	if c.NATS.AckWaitSeconds < 1 {
+		errs = append(errs, "NATS_ACK_WAIT_SECONDS must be positive")
+	}
+	if c.NATS.MaxRedelivery < 1 {
+		errs = append(errs, "NATS_MAX_REDELIVERY must be positive")
+	}
+	if c.Cache.Size < 0 {
+		errs = append(errs, "CACHE_SIZE must not be negative")
+	}
+	if c.Cache.TTLSeconds < 0 {
+		errs = append(errs, "CACHE_TTL_SECONDS must not be negative")
+	}
+	switch c.Cache.ShutdownPolicy {
+	case CacheShutdownWipe, CacheShutdownKeep, CacheShutdownSnapshot:
+	default:
+		errs = append(errs, "CACHE_SHUTDOWN_POLICY must be wipe, keep or snapshot")
+	}
+	if c.Cache.SnapshotSize < 0 {
+		errs = append(errs, "CACHE_SNAPSHOT_SIZE must not be negative")
+	}
+	if c.Cache.SnapshotFile != "" && c.Cache.SnapshotIntervalSeconds < 1 {
+		errs = append(errs, "CACHE_SNAPSHOT_INTERVAL_SECONDS must be positive")
+	}
+	if c.Shutdown.TimeoutSeconds < 1 {
+		errs = append(errs, "SHUTDOWN_TIMEOUT_SECONDS must be positive")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
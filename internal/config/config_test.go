@@ -0,0 +1,189 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Параметры, которые проверяют тесты: значения из окружения процесса не должны на них влиять
+var testEnv = []string{"CONFIG_FILE", "CACHE_SIZE", "APP_KEY", "NATS_EMBEDDED", "NATS_SUBJECT", "NATS_DLQ_SUBJECT"}
+
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if v, ok := os.LookupEnv(key); ok {
+			os.Unsetenv(key)
+			t.Cleanup(func() { os.Setenv(key, v) })
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := `
+[nats]
+subject = "orders"
+embedded = true
+
+[cache]
+size = 20
+app_key = "file"
+`
+	tests := []struct {
+		name     string
+		file     string // содержимое файла конфигурации, пусто - файла нет
+		env      map[string]string
+		args     []string
+		size     int
+		appKey   string
+		embedded bool
+		dlq      string
+		err      string
+	}{
+		{
+			name: "defaults",
+			size: 10,
+			dlq:  ".dlq",
+		},
+		{
+			name:     "file overrides defaults",
+			file:     file,
+			size:     20,
+			appKey:   "file",
+			embedded: true,
+			dlq:      "orders.dlq",
+		},
+		{
+			name:     "env overrides file",
+			file:     file,
+			env:      map[string]string{"CACHE_SIZE": "30", "NATS_EMBEDDED": "false"},
+			size:     30,
+			appKey:   "file",
+			embedded: false,
+			dlq:      "orders.dlq",
+		},
+		{
+			name:     "flags override env",
+			file:     file,
+			env:      map[string]string{"CACHE_SIZE": "30", "APP_KEY": "env"},
+			args:     []string{"-cache-size", "40", "-nats-dlq-subject", "orders.dead"},
+			size:     40,
+			appKey:   "env",
+			embedded: true,
+			dlq:      "orders.dead",
+		},
+		{
+			name: "invalid env value",
+			env:  map[string]string{"CACHE_SIZE": "ten"},
+			err:  `CACHE_SIZE: "ten" is not an integer`,
+		},
+		{
+			name: "invalid flag value",
+			args: []string{"-nats-embedded", "maybe"},
+			err:  `NATS_EMBEDDED: "maybe" is not a boolean`,
+		},
+		{
+			name: "explicit config file is required",
+			args: []string{"-config", "missing.toml"},
+			err:  "config file missing.toml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, testEnv...)
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.toml")
+				if err := ioutil.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatalf("WriteFile() error: %v", err)
+				}
+				t.Setenv("CONFIG_FILE", path)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg, err := load(flag.NewFlagSet("test", flag.ContinueOnError), tt.args, func(setting) bool { return true })
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("load() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load() error: %v", err)
+			}
+			if cfg.Cache.Size != tt.size || cfg.Cache.AppKey != tt.appKey || cfg.NATS.Embedded != tt.embedded ||
+				cfg.NATS.DLQSubject != tt.dlq {
+				t.Errorf("size %d, app key %q, embedded %v, dlq %q; want %d, %q, %v, %q", cfg.Cache.Size, cfg.Cache.AppKey,
+					cfg.NATS.Embedded, cfg.NATS.DLQSubject, tt.size, tt.appKey, tt.embedded, tt.dlq)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		c := Default()
+		c.DB.Storage = StorageMemory
+		c.NATS.Embedded = true
+		c.NATS.ClusterID, c.NATS.ClientID, c.NATS.Subject = "test-cluster", "test", "orders"
+		c.NATS.DLQSubject, c.NATS.StatusSubject = "orders.dlq", "orders.status"
+		c.Cache.AppKey = "test"
+		return c
+	}
+	tests := []struct {
+		name   string
+		mutate func(c *Config)
+		err    string // пусто - конфигурация корректна
+	}{
+		{name: "valid", mutate: func(c *Config) {}},
+		{name: "postgres needs connection", mutate: func(c *Config) { c.DB.Storage = StoragePostgres }, err: "DB_USERNAME, DB_HOST and DB_NAME are required"},
+		{name: "unknown storage", mutate: func(c *Config) { c.DB.Storage = "mysql" }, err: "DB_STORAGE must be postgres or memory"},
+		{name: "app key is required", mutate: func(c *Config) { c.Cache.AppKey = " " }, err: "APP_KEY is required"},
+		{name: "nats hosts without embedded server", mutate: func(c *Config) { c.NATS.Embedded = false }, err: "NATS_HOSTS is required"},
+		{name: "unknown decode policy", mutate: func(c *Config) { c.NATS.DecodePolicy = "loose" }, err: "NATS_DECODE_POLICY"},
+		{name: "status subject equals orders subject", mutate: func(c *Config) { c.NATS.StatusSubject = "orders" }, err: "NATS_STATUS_SUBJECT must differ"},
+		{name: "negative cache size", mutate: func(c *Config) { c.Cache.Size = -1 }, err: "CACHE_SIZE must not be negative"},
+		{name: "unknown shutdown policy", mutate: func(c *Config) { c.Cache.ShutdownPolicy = "dump" }, err: "CACHE_SHUTDOWN_POLICY"},
+		{name: "snapshot file needs interval", mutate: func(c *Config) { c.Cache.SnapshotFile, c.Cache.SnapshotIntervalSeconds = "cache.snap", 0 }, err: "CACHE_SNAPSHOT_INTERVAL_SECONDS"},
+		{name: "zero shutdown timeout", mutate: func(c *Config) { c.Shutdown.TimeoutSeconds = 0 }, err: "SHUTDOWN_TIMEOUT_SECONDS"},
+		{
+			name:   "all errors are reported",
+			mutate: func(c *Config) { c.NATS.MaxRedelivery, c.NATS.AckWaitSeconds = 0, 0 },
+			err:    "NATS_ACK_WAIT_SECONDS must be positive; NATS_MAX_REDELIVERY must be positive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.mutate(&c)
+			err := c.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Validate() error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// Файл конфигурации из репозитория разбирается и не меняет значения по умолчанию политик кеша
+func TestDefaultConfigFile(t *testing.T) {
+	c := Default()
+	if err := c.loadFile(filepath.Join("..", "..", DefaultConfigFile), true); err != nil {
+		t.Fatalf("loadFile() error: %v", err)
+	}
+	if c.Cache.ShutdownPolicy != Default().Cache.ShutdownPolicy {
+		t.Errorf("shutdown_policy = %q, default is %q", c.Cache.ShutdownPolicy, Default().Cache.ShutdownPolicy)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() error: %v", err)
+	}
+}
@@ -1,134 +1,556 @@
 package db
 
 import (
+	"container/list"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/metrics"
 )
 
+// Интервал очистки таблицы cache от строк, которые не понадобятся при восстановлении
+const cachePruneInterval = time.Minute
+
+// Элемент кеша: Order и время, после которого он считается устаревшим
+type cacheEntry struct {
+	oid       int64
+	order     Order
+	expiresAt time.Time // нулевое значение - без TTL
+}
+
+// Счетчики работы кеша
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"` // вытеснение по LRU
+	Expired   uint64 `json:"expired"`   // удаление по TTL
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+}
+
+// LRU-кеш Order: при переполнении вытесняется Order, к которому дольше всего не обращались
 type Cache struct {
-	buffer  map[int64]Order
-	queue   []int64
-	bufSize int
-	pos     int
-	DBInst  *DB
-	name    string
-	mutex   *sync.RWMutex
+	items     map[int64]*list.Element // id -> элемент списка recency
+	recency   *list.List              // в начале - последние использованные Order, в конце - кандидаты на вытеснение
+	uids      map[string]int64        // вторичный индекс: OrderUID -> id
+	bufSize   int
+	ttl       time.Duration
+	appKey    string
+	policy    string        // политика сохранения списка кеша в БД при завершении работы
+	snapN     int           // число заказов для политики snapshot
+	snapFile  string        // локальный файл снимка кеша
+	snapEvery time.Duration // интервал записи снимка
+	warm      int32         // 1 - восстановление из БД завершено
+	gen       uint64        // поколение кеша: увеличивается при инвалидации и изменении Order (под c.mutex)
+	done      chan struct{} // закрывается при завершении работы
+	stats     CacheStats
+	DBInst    OrderStore
+	broadcast func(oid int64) error // рассылка id измененного Order остальным репликам (задается StreamingHandler)
+	name      string
+	mutex     *sync.Mutex
 }
 
-func NewCache(db *DB) *Cache {
+func NewCache(cfg config.CacheConfig, db OrderStore) *Cache {
 	csh := Cache{}
-	csh.Init(db)
+	csh.Init(cfg, db)
 	return &csh
 }
 
-// Инициализация кеша - установка размера, восстанавление
-func (c *Cache) Init(db *DB) {
+// Инициализация кеша - установка размера и TTL, восстанавление
+func (c *Cache) Init(cfg config.CacheConfig, db OrderStore) {
 	c.DBInst = db
-	db.SetCahceInstance(c)
 	c.name = "Cahce"
-	c.mutex = &sync.RWMutex{}
+	c.mutex = &sync.Mutex{}
 
-	// Установка размера кеша
-	bufSize, err := strconv.Atoi(os.Getenv("CACHE_SIZE"))
-	if err != nil {
-		log.Printf("%s: Init() warning: set default cache size 10\n", c.name)
-		bufSize = 10
+	c.bufSize = cfg.Size
+	c.ttl = time.Duration(cfg.TTLSeconds) * time.Second // 0 - без ограничения
+	c.appKey = cfg.AppKey
+	c.policy = cfg.ShutdownPolicy
+	c.snapN = cfg.SnapshotSize
+	if c.snapN == 0 || c.snapN > c.bufSize {
+		c.snapN = c.bufSize
 	}
+	c.snapFile = cfg.SnapshotFile
+	c.snapEvery = time.Duration(cfg.SnapshotIntervalSeconds) * time.Second
+	c.items = make(map[int64]*list.Element, c.bufSize)
+	c.recency = list.New()
+	c.uids = make(map[string]int64, c.bufSize)
+	c.done = make(chan struct{})
 
-	c.bufSize = bufSize
-	c.buffer = make(map[int64]Order, c.bufSize)
-	c.queue = make([]int64, c.bufSize)
-
-	// Восстанавление кеша из базы данных, если он есть в бд
-	c.getCacheFromDatabase()
+	// Восстанавление кеша по локальному снимку, если он задан и актуален, иначе - из базы данных, если он есть в бд.
+	// Если БД недоступна - повторяем в фоне
+	load := c.getCacheFromDatabase
+	if c.snapFile != "" {
+		if snap, ok := c.openSnapshot(); ok {
+			load = func() bool { return c.loadSnapshot(snap) }
+		}
+	}
+	if !load() {
+		go c.retryLoad(load)
+	}
+	go c.pruneDatabaseCache()
+	if c.snapFile != "" {
+		go c.snapshotLoop()
+	}
 }
 
-// Восстанавливаем кеш из базы данных: читаем из файла содержимое кеша
-func (c *Cache) getCacheFromDatabase() {
+// Восстанавливаем кеш из базы данных. Возвращает false, если БД недоступна или Order изменились во время
+// загрузки и восстановление нужно повторить
+func (c *Cache) getCacheFromDatabase() bool {
 	log.Printf("%v: check & download cache from database\n", c.name)
-	buf, queue, pos, err := c.DBInst.GetCacheState(c.bufSize)
+	gen := c.generation()
+	queue, buf, err := c.DBInst.GetCacheState(c.appKey, c.bufSize)
+	if errors.Is(err, ErrCacheEmpty) {
+		log.Printf("%s: getCacheFromDatabase(): cache is empty\n", c.name)
+		atomic.StoreInt32(&c.warm, 1)
+		return true
+	}
 	if err != nil {
-		log.Printf("%s: getCacheFromDatabase() warning: can't download from database or cache is empty: %v\n", c.name, err)
-		return
+		log.Printf("%s: getCacheFromDatabase() warning: can't download from database: %v\n", c.name, err)
+		return false
 	}
 
-	// Проверяем, не заполнили ли буфер полностью. Если да - сбрасываем указатель на начало циклической очереди
-	if pos == c.bufSize {
-		pos = 0
+	c.mutex.Lock()
+	if c.gen != gen {
+		c.mutex.Unlock()
+		log.Printf("%s: getCacheFromDatabase(): orders changed while loading, retrying\n", c.name)
+		return false
 	}
+	c.restore(queue, buf)
+	log.Printf("%s: cache downloaded from database: %d orders", c.name, c.recency.Len())
+	c.mutex.Unlock()
+	atomic.StoreInt32(&c.warm, 1)
+	return true
+}
 
+// Повтор восстановления кеша с увеличивающимся интервалом, пока БД не станет доступна
+func (c *Cache) retryLoad(load func() bool) {
+	delay := time.Second
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+		if load() {
+			return
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// Периодическая очистка таблицы cache: SendOrderIDToCache только добавляет строки, а при восстановлении
+// читаются лишь последние bufSize из них
+func (c *Cache) pruneDatabaseCache() {
+	ticker := time.NewTicker(cachePruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		pruned, err := c.DBInst.PruneCache(c.appKey, c.bufSize)
+		if err != nil {
+			log.Printf("%s: pruneDatabaseCache() warning: %v\n", c.name, err)
+			continue
+		}
+		if pruned > 0 {
+			log.Printf("%s: %d stale rows pruned from cache table\n", c.name, pruned)
+		}
+	}
+}
+
+// Добавление восстановленных Order в конец списка recency (от "новых" к "старым"), не вытесняя
+// Order, попавшие в кеш во время восстановления (вызывается под c.mutex)
+func (c *Cache) restore(queue []int64, buf map[int64]Order) {
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+	for i := len(queue) - 1; i >= 0 && c.recency.Len() < c.bufSize; i-- {
+		oid := queue[i]
+		o, ok := buf[oid]
+		if _, exists := c.items[oid]; !ok || exists {
+			continue
+		}
+		c.items[oid] = c.recency.PushBack(&cacheEntry{oid: oid, order: o, expiresAt: expiresAt})
+		c.index(oid, o)
+	}
+	metrics.CacheSize.Set(float64(c.recency.Len()))
+}
+
+// Готовность кеша: восстановление из БД завершено
+func (c *Cache) Ready() error {
+	if atomic.LoadInt32(&c.warm) == 0 {
+		return errors.New("cache warm-up in progress")
+	}
+	return nil
+}
+
+// Текущее поколение кеша. Order, прочитанный из БД, можно сохранить в кеш, только если поколение
+// не изменилось с начала чтения: иначе прочитанная версия могла устареть
+func (c *Cache) generation() uint64 {
 	c.mutex.Lock()
-	c.buffer = buf
-	c.queue = queue
-	c.pos = pos
+	defer c.mutex.Unlock()
+	return c.gen
+}
+
+// Сохранение в кеш Order, прочитанного из БД в поколении gen. Возвращает false, если за время чтения
+// Order были инвалидированы или изменены и прочитанная версия не закеширована
+func (c *Cache) setLoaded(oid int64, o Order, gen uint64) bool {
+	if c.bufSize <= 0 {
+		return false
+	}
+	c.mutex.Lock()
+	if c.gen != gen {
+		c.mutex.Unlock()
+		return false
+	}
+	isNew := c.put(oid, o)
 	c.mutex.Unlock()
-	log.Printf("%s: cache downloaded from database: queue is: %v, next position in queue is: %v", c.name, c.queue, c.pos)
+
+	if isNew {
+		c.DBInst.SendOrderIDToCache(c.appKey, oid)
+	}
+	return true
+}
+
+// Сохранение Order в БД и, после успешной записи, в кеш. Если Order с таким OrderUID уже сохранен,
+// возвращается его id и ErrOrderAlreadyExists
+func (c *Cache) AddOrder(o Order) (int64, error) {
+	oid, err := c.DBInst.AddOrder(o)
+	if err != nil {
+		return oid, err
+	}
+	c.SetOrder(oid, o)
+	return oid, nil
 }
 
 // Сохранение в кеш после успешного добавления Order в БД
 func (c *Cache) SetOrder(oid int64, o Order) {
-	if c.bufSize > 0 {
-		c.mutex.Lock()
-		// сохраняем в циклическую очередь новый orderId (если на позиции pos будет Order, он будет перезаписан)
-		c.queue[c.pos] = oid
-		c.pos++
-		if c.pos == c.bufSize {
-			c.pos = 0
-		}
+	if c.bufSize <= 0 {
+		log.Printf("%s: cache is off: bufSize = 0 (see config.toml)\n", c.name)
+		return
+	}
 
-		// сохраняем в буфер новый Order
-		c.buffer[oid] = o
-		c.mutex.Unlock()
+	c.mutex.Lock()
+	isNew := c.put(oid, o)
+	size := c.recency.Len()
+	c.mutex.Unlock()
 
-		// сохраняем в таблицу Cache в БД новый OrderID - для восстановления кеша после сбоя
-		c.DBInst.SendOrderIDToCache(oid)
-		log.Printf("%s: Order successfull added to Cahce, Order position in queue is %v\n", c.name, c.pos)
-	} else {
-		log.Printf("%s: cache is off: bufSize = 0 (see config.go)\n", c.name)
+	// сохраняем в таблицу Cache в БД новый OrderID - для восстановления кеша после сбоя
+	if isNew {
+		c.DBInst.SendOrderIDToCache(c.appKey, oid)
 	}
-	//fmt.Println(c.buffer)
-	log.Printf("%s: queue is: %v, next position in queue is: %v", c.name, c.queue, c.pos)
+	log.Printf("%s: Order (id:%d) successfull added to Cahce, cache size is %d/%d\n", c.name, oid, size, c.bufSize)
 }
 
-// Получаем Order по ID из кеша. Преобразование к модели для выдачи
-func (c *Cache) GetOrderOutById(oid int64) (*OrderOut, error) {
-	var ou *OrderOut = &OrderOut{}
-	var o Order
-	var err error
+// Добавление или обновление Order в начале списка recency с вытеснением лишних (вызывается под c.mutex).
+// Возвращает true, если Order ранее не было в кеше
+func (c *Cache) put(oid int64, o Order) bool {
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+
+	if el, ok := c.items[oid]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.unindex(entry)
+		entry.order = o
+		entry.expiresAt = expiresAt
+		c.index(oid, o)
+		c.recency.MoveToFront(el)
+		return false
+	}
+
+	c.items[oid] = c.recency.PushFront(&cacheEntry{oid: oid, order: o, expiresAt: expiresAt})
+	c.index(oid, o)
+	for c.recency.Len() > c.bufSize {
+		c.remove(c.recency.Back())
+		c.stats.Evictions++
+		metrics.CacheEvictions.WithLabelValues("lru").Inc()
+	}
+	metrics.CacheSize.Set(float64(c.recency.Len()))
+	return true
+}
+
+// Получение Order из кеша с обновлением recency (вызывается под c.mutex)
+func (c *Cache) get(oid int64) (Order, bool) {
+	el, ok := c.items[oid]
+	if !ok {
+		c.stats.Misses++
+		metrics.CacheMisses.Inc()
+		return Order{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.remove(el)
+		c.stats.Expired++
+		c.stats.Misses++
+		metrics.CacheEvictions.WithLabelValues("ttl").Inc()
+		metrics.CacheMisses.Inc()
+		metrics.CacheSize.Set(float64(c.recency.Len()))
+		return Order{}, false
+	}
+	c.recency.MoveToFront(el)
+	c.stats.Hits++
+	metrics.CacheHits.Inc()
+	return entry.order, true
+}
+
+// Удаление элемента из кеша и вторичных индексов (вызывается под c.mutex)
+func (c *Cache) remove(el *list.Element) {
+	entry := c.recency.Remove(el).(*cacheEntry)
+	delete(c.items, entry.oid)
+	c.unindex(entry)
+}
+
+// Обновление вторичного индекса для Order (вызывается под c.mutex)
+func (c *Cache) index(oid int64, o Order) {
+	if o.OrderUID != "" {
+		c.uids[o.OrderUID] = oid
+	}
+}
+
+// Удаление Order из вторичного индекса, если он указывает на него (вызывается под c.mutex)
+func (c *Cache) unindex(entry *cacheEntry) {
+	if c.uids[entry.order.OrderUID] == entry.oid {
+		delete(c.uids, entry.order.OrderUID)
+	}
+}
+
+// Текущие счетчики кеша
+func (c *Cache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Size = c.recency.Len()
+	stats.Capacity = c.bufSize
+	return stats
+}
+
+// Получаем Order по OrderUID: id ищется во вторичном индексе кеша, если его там нет - в БД
+func (c *Cache) GetOrderByUID(uid string) (*Order, error) {
+	c.mutex.Lock()
+	oid, isExist := c.uids[uid]
+	c.mutex.Unlock()
+
+	if !isExist {
+		var err error
+		oid, err = c.DBInst.GetOrderIDByUID(uid)
+		if err != nil {
+			log.Printf("%s: GetOrderByUID(): ошибка получения id Order (uid:%s): %v\n", c.name, uid, err)
+			return nil, err
+		}
+	}
+	return c.GetOrderById(oid)
+}
 
-	c.mutex.RLock()
+// Получаем Order по TrackNumber. TrackNumber не уникален, а в кеше может не оказаться последнего Order
+// с этим номером, поэтому id всегда ищется в БД, а сам Order - в кеше
+func (c *Cache) GetOrderByTrackNumber(trackNumber string) (*Order, error) {
+	oid, err := c.DBInst.GetOrderIDByTrackNumber(trackNumber)
+	if err != nil {
+		log.Printf("%s: GetOrderByTrackNumber(): ошибка получения id Order (track:%s): %v\n", c.name, trackNumber, err)
+		return nil, err
+	}
+	return c.GetOrderById(oid)
+}
+
+// Получаем Order по ID из кеша. Если его нет в кеше - из БД
+func (c *Cache) GetOrderById(oid int64) (*Order, error) {
+	c.mutex.Lock()
 	// проверка в кеше. Если нет - идем в базу
-	o, isExist := c.buffer[oid]
-	c.mutex.RUnlock()
+	o, isExist := c.get(oid)
+	gen := c.gen
+	c.mutex.Unlock()
 
 	if isExist {
-		log.Printf("%s: OrderOut (id:%d) взят из кеша!\n", c.name, oid)
-	} else {
-		// запрос Order к базе данных
-		o, err = c.DBInst.GetOrderByID(oid)
-		if err != nil {
-			log.Printf("%s: GetOrderOutById(): ошибка получения Order: %v\n", c.name, err)
-			return ou, err
+		log.Printf("%s: Order (id:%d) взят из кеша!\n", c.name, oid)
+		return &o, nil
+	}
+
+	// запрос Order к базе данных
+	o, err := c.DBInst.GetOrderByID(oid)
+	if err != nil {
+		log.Printf("%s: GetOrderById(): ошибка получения Order: %v\n", c.name, err)
+		return nil, err
+	}
+	// Сохранение в кеш, если Order не изменился во время чтения
+	if !c.setLoaded(oid, o, gen) {
+		log.Printf("%s: Order (id:%d) взят из бд без сохранения в кеш\n", c.name, oid)
+		return &o, nil
+	}
+	log.Printf("%s: Order (id:%d) взят из бд и сохранен в кеш!\n", c.name, oid)
+	return &o, nil
+}
+
+// Получаем несколько Order по списку id: найденные в кеше берутся из памяти, остальные загружаются из БД одним пакетом.
+// Загруженные из БД Order не кешируются, чтобы пакетное чтение не вытесняло из кеша часто запрашиваемые
+func (c *Cache) GetOrdersByIds(oids []int64) (map[int64]Order, error) {
+	orders := make(map[int64]Order, len(oids))
+	missed := make([]int64, 0, len(oids))
+
+	c.mutex.Lock()
+	for _, oid := range oids {
+		if o, ok := c.get(oid); ok {
+			orders[oid] = o
+		} else {
+			missed = append(missed, oid)
 		}
-		// Сохранение в кеш
-		c.SetOrder(oid, o)
-		log.Printf("%s: OrderOut (id:%d) взят из бд и сохранен в кеш!\n", c.name, oid)
 	}
+	c.mutex.Unlock()
 
-	// Преобразование к модели для выдачи
-	ou.CustomerID = o.CustomerID
-	ou.DeliveryService = o.DeliveryService
-	ou.Entry = o.Entry
-	ou.OrderUID = o.OrderUID
-	ou.TotalPrice = o.GetTotalPrice()
-	ou.TrackNumber = o.TrackNumber
-	return ou, nil
+	if len(missed) == 0 {
+		return orders, nil
+	}
+	loaded, err := c.DBInst.GetOrdersByIDs(missed)
+	if err != nil {
+		log.Printf("%s: GetOrdersByIds(): ошибка получения Orders: %v\n", c.name, err)
+		return orders, err
+	}
+	for oid, o := range loaded {
+		orders[oid] = o
+	}
+	log.Printf("%s: %d Orders взяты из кеша, %d - из бд\n", c.name, len(oids)-len(missed), len(loaded))
+	return orders, nil
+}
+
+// Установка функции рассылки инвалидаций кеша другим репликам
+func (c *Cache) SetInvalidationBroadcaster(broadcast func(oid int64) error) {
+	c.mutex.Lock()
+	c.broadcast = broadcast
+	c.mutex.Unlock()
 }
 
+// Обновление Order: запись в БД, обновление записи в кеше этой реплики и рассылка инвалидации остальным.
+// Если рассылка не удалась, возвращается ErrInvalidationPending: Order в БД уже обновлен
+func (c *Cache) UpdateOrder(oid int64, o Order) error {
+	if err := c.DBInst.UpdateOrder(oid, o); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	// чтения, начатые до записи в БД, не должны закешировать прежнюю версию
+	c.gen++
+	if _, ok := c.items[oid]; ok {
+		c.put(oid, o)
+	}
+	broadcast := c.broadcast
+	c.mutex.Unlock()
+	log.Printf("%s: Order (id:%d) updated\n", c.name, oid)
+
+	if broadcast != nil {
+		if err := broadcast(oid); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidationPending, err)
+		}
+	}
+	return nil
+}
+
+// Удаление Order из кеша по уведомлению об изменении: следующий запрос загрузит его из БД
+func (c *Cache) Invalidate(oid int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Order может читаться из БД в этот момент: прочитанная до изменения версия не попадет в кеш
+	c.gen++
+	el, ok := c.items[oid]
+	if !ok {
+		return
+	}
+	c.remove(el)
+	metrics.CacheEvictions.WithLabelValues("invalidation").Inc()
+	metrics.CacheSize.Set(float64(c.recency.Len()))
+	log.Printf("%s: Order (id:%d) invalidated\n", c.name, oid)
+}
+
+// Очистка кеша в памяти: уведомления об изменениях могли быть пропущены (например, пока не было соединения с NATS)
+func (c *Cache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gen++
+	n := c.recency.Len()
+	for c.recency.Len() > 0 {
+		c.remove(c.recency.Back())
+	}
+	metrics.CacheEvictions.WithLabelValues("invalidation").Add(float64(n))
+	metrics.CacheSize.Set(0)
+	log.Printf("%s: cache purged, %d orders removed\n", c.name, n)
+}
+
+// Получаем Order по ID из кеша. Преобразование к модели для выдачи
+func (c *Cache) GetOrderOutById(oid int64) (*OrderOut, error) {
+	o, err := c.GetOrderById(oid)
+	if err != nil {
+		return &OrderOut{}, err
+	}
+	return NewOrderOut(o), nil
+}
+
+// Страница списка Order с фильтрами. Не кешируется: состав страницы зависит от фильтров и меняется с каждым новым Order
+func (c *Cache) ListOrders(filter OrderFilter) (OrderPage, error) {
+	return c.DBInst.ListOrders(filter)
+}
+
+// История статусов Order. Не кешируется: статус меняется событиями, которые обрабатывает любая из реплик
+func (c *Cache) GetOrderHistory(uid string) (OrderHistory, error) {
+	return c.DBInst.GetOrderHistory(uid)
+}
+
+// Завершение работы кеша: список заказов кеша в БД сохраняется согласно политике CACHE_SHUTDOWN_POLICY
 func (c *Cache) Finish() {
 	log.Printf("%s: Finish...", c.name)
-	c.DBInst.ClearCache()
+	close(c.done)
+	stats := c.Stats()
+	log.Printf("%s: hits: %d, misses: %d, evictions: %d, expired: %d", c.name, stats.Hits, stats.Misses, stats.Evictions, stats.Expired)
+
+	if c.snapFile != "" && c.Ready() == nil {
+		if err := c.saveSnapshot(); err != nil {
+			log.Printf("%s: Finish() warning: unable to write cache snapshot: %v\n", c.name, err)
+		}
+	}
+
+	switch c.policy {
+	case config.CacheShutdownKeep:
+		if _, err := c.DBInst.PruneCache(c.appKey, c.bufSize); err != nil {
+			log.Printf("%s: Finish() warning: %v\n", c.name, err)
+		}
+		log.Printf("%s: cache list is kept in database\n", c.name)
+	case config.CacheShutdownSnapshot:
+		// кеш не успел восстановиться из БД: снимок вытеснил бы сохраненный список
+		if c.Ready() != nil {
+			log.Printf("%s: cache is not warm, snapshot skipped\n", c.name)
+			break
+		}
+		oids := c.hottest(c.snapN)
+		if err := c.DBInst.ReplaceCache(c.appKey, oids); err != nil {
+			log.Printf("%s: Finish() warning: unable to save cache snapshot: %v\n", c.name, err)
+		}
+	default:
+		c.DBInst.ClearCache(c.appKey)
+	}
 	log.Printf("%s: Finished", c.name)
 }
+
+// Не более n id Order кеша, от последних использованных к давно не использованным. Устаревшие по TTL не учитываются
+func (c *Cache) hottest(n int) []int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	oids := make([]int64, 0, n)
+	for el := c.recency.Front(); el != nil && len(oids) < n; el = el.Next() {
+		entry := el.Value.(*cacheEntry)
+		if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+			continue
+		}
+		oids = append(oids, entry.oid)
+	}
+	return oids
+}
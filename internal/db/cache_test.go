@@ -0,0 +1,334 @@
+package db_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+)
+
+// Кеш размера size поверх пустого MemoryStore
+func newTestCache(t *testing.T, size int) (*db.Cache, *db.MemoryStore) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	return testutil.NewCache(t, store, size), store
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		steps     []string // "+a" - сохранение Order "a" в хранилище, "a" - запрос Order "a" через кеш
+		cached    []string
+		evictions uint64
+	}{
+		{
+			name:   "fits in cache",
+			size:   3,
+			steps:  []string{"+a", "+b", "+c"},
+			cached: []string{"a", "b", "c"},
+		},
+		{
+			name:      "least recently added is evicted",
+			size:      2,
+			steps:     []string{"+a", "+b", "+c"},
+			cached:    []string{"b", "c"},
+			evictions: 1,
+		},
+		{
+			name:      "access keeps order in cache",
+			size:      2,
+			steps:     []string{"+a", "+b", "a", "+c"},
+			cached:    []string{"a", "c"},
+			evictions: 1,
+		},
+		{
+			name:      "evicted order is loaded back from store",
+			size:      2,
+			steps:     []string{"+a", "+b", "+c", "a"},
+			cached:    []string{"a", "c"},
+			evictions: 2,
+		},
+		{
+			name:   "zero size disables cache",
+			size:   0,
+			steps:  []string{"+a", "a"},
+			cached: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csh, _ := newTestCache(t, tt.size)
+			oids := make(map[string]int64)
+			for _, step := range tt.steps {
+				if step[0] == '+' {
+					for uid, oid := range testutil.AddOrders(t, csh, step[1:]) {
+						oids[uid] = oid
+					}
+					continue
+				}
+				if _, err := csh.GetOrderById(oids[step]); err != nil {
+					t.Fatalf("GetOrderById(%s) error: %v", step, err)
+				}
+			}
+
+			if got := csh.CachedUIDs(); !equalStrings(got, tt.cached) {
+				t.Errorf("cached = %v, want %v", got, tt.cached)
+			}
+			if stats := csh.Stats(); stats.Evictions != tt.evictions || stats.Size != len(tt.cached) {
+				t.Errorf("stats = %+v, want %d evictions and size %d", stats, tt.evictions, len(tt.cached))
+			}
+		})
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		wait    time.Duration
+		hits    uint64
+		misses  uint64
+		expired uint64
+	}{
+		{name: "no ttl", ttl: 0, wait: 20 * time.Millisecond, hits: 1},
+		{name: "fresh entry", ttl: time.Minute, wait: 0, hits: 1},
+		{name: "expired entry is reloaded from store", ttl: 10 * time.Millisecond, wait: 20 * time.Millisecond, misses: 1, expired: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csh, _ := newTestCache(t, 10)
+			csh.SetTTL(tt.ttl)
+			oid := testutil.AddOrders(t, csh, "a")["a"]
+			time.Sleep(tt.wait)
+
+			o, err := csh.GetOrderById(oid)
+			if err != nil {
+				t.Fatalf("GetOrderById() error: %v", err)
+			}
+			if o.OrderUID != "a" {
+				t.Errorf("OrderUID = %q, want a", o.OrderUID)
+			}
+			stats := csh.Stats()
+			if stats.Hits != tt.hits || stats.Misses != tt.misses || stats.Expired != tt.expired {
+				t.Errorf("stats = %+v, want hits %d, misses %d, expired %d", stats, tt.hits, tt.misses, tt.expired)
+			}
+			// после промаха Order снова в кеше
+			if stats.Size != 1 {
+				t.Errorf("cache size = %d, want 1", stats.Size)
+			}
+		})
+	}
+}
+
+func TestCacheIndexCleanup(t *testing.T) {
+	tests := []struct {
+		name string
+		// действие над кешем с Order "a" и "b" (размер кеша 2)
+		act func(t *testing.T, c *db.Cache, store *db.MemoryStore, oids map[string]int64)
+		// OrderUID, оставшиеся во вторичном индексе
+		uids []string
+	}{
+		{
+			name: "eviction",
+			act:  func(t *testing.T, c *db.Cache, _ *db.MemoryStore, _ map[string]int64) { testutil.AddOrders(t, c, "c") },
+			uids: []string{"b", "c"},
+		},
+		{
+			name: "invalidation",
+			act:  func(t *testing.T, c *db.Cache, _ *db.MemoryStore, oids map[string]int64) { c.Invalidate(oids["a"]) },
+			uids: []string{"b"},
+		},
+		{
+			name: "ttl expiry",
+			act: func(t *testing.T, c *db.Cache, store *db.MemoryStore, oids map[string]int64) {
+				c.Expire(oids["a"])
+				// Order удален из хранилища: после истечения TTL его не будет ни в кеше, ни в индексе
+				store.DeleteOrder(oids["a"])
+				if _, err := c.GetOrderById(oids["a"]); err != db.ErrOrderNotFound {
+					t.Fatalf("GetOrderById() error = %v, want ErrOrderNotFound", err)
+				}
+			},
+			uids: []string{"b"},
+		},
+		{
+			name: "update",
+			act: func(t *testing.T, c *db.Cache, _ *db.MemoryStore, oids map[string]int64) {
+				o := testutil.Order("a")
+				o.TrackNumber = "TRACK-new"
+				if err := c.UpdateOrder(oids["a"], o); err != nil {
+					t.Fatalf("UpdateOrder() error: %v", err)
+				}
+			},
+			uids: []string{"a", "b"},
+		},
+		{
+			name: "purge",
+			act:  func(t *testing.T, c *db.Cache, _ *db.MemoryStore, _ map[string]int64) { c.Purge() },
+			uids: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csh, store := newTestCache(t, 2)
+			oids := testutil.AddOrders(t, csh, "a", "b")
+			tt.act(t, csh, store, oids)
+
+			uids := make([]string, 0)
+			for uid, cached := range csh.UIDIndex() {
+				if !cached {
+					t.Errorf("uid index %s points to order missing in cache", uid)
+				}
+				uids = append(uids, uid)
+			}
+			sort.Strings(uids)
+
+			if !equalStrings(uids, tt.uids) {
+				t.Errorf("uid index = %v, want %v", uids, tt.uids)
+			}
+		})
+	}
+}
+
+// MemoryStore, вызывающий onGet после чтения Order: изменение Order между чтением из БД и сохранением в кеш
+type racyStore struct {
+	*db.MemoryStore
+	onGet func(oid int64)
+}
+
+func (s *racyStore) GetOrderByID(oid int64) (db.Order, error) {
+	o, err := s.MemoryStore.GetOrderByID(oid)
+	if onGet := s.onGet; onGet != nil {
+		s.onGet = nil
+		onGet(oid)
+	}
+	return o, err
+}
+
+func TestCacheGetOrderByTrackNumber(t *testing.T) {
+	csh, _ := newTestCache(t, 10)
+	older, newer := testutil.Order("a"), testutil.Order("b")
+	older.TrackNumber, newer.TrackNumber = "TRACK-shared", "TRACK-shared"
+	if _, err := csh.AddOrder(older); err != nil {
+		t.Fatalf("AddOrder() error: %v", err)
+	}
+	oid, err := csh.AddOrder(newer)
+	if err != nil {
+		t.Fatalf("AddOrder() error: %v", err)
+	}
+	// в кеше остался только более старый Order с тем же TrackNumber
+	csh.Invalidate(oid)
+
+	o, err := csh.GetOrderByTrackNumber("TRACK-shared")
+	if err != nil {
+		t.Fatalf("GetOrderByTrackNumber() error: %v", err)
+	}
+	if o.OrderUID != "b" {
+		t.Errorf("OrderUID = %q, want the latest order b", o.OrderUID)
+	}
+}
+
+func TestCacheStaleLoad(t *testing.T) {
+	updated := testutil.Order("a")
+	updated.TrackNumber = "TRACK-new"
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T, c *db.Cache, store *db.MemoryStore, oid int64) // выполняется после чтения Order из БД
+		cached bool                                                              // прочитанная версия сохранена в кеш
+		track  string                                                            // TrackNumber при следующем запросе
+	}{
+		{
+			name:   "no change",
+			change: func(*testing.T, *db.Cache, *db.MemoryStore, int64) {},
+			cached: true,
+			track:  "TRACK-a",
+		},
+		{
+			name: "invalidation from other replica",
+			change: func(t *testing.T, c *db.Cache, store *db.MemoryStore, oid int64) {
+				if err := store.UpdateOrder(oid, updated); err != nil {
+					t.Fatalf("UpdateOrder() error: %v", err)
+				}
+				c.Invalidate(oid)
+			},
+			track: "TRACK-new",
+		},
+		{
+			name: "local update",
+			change: func(t *testing.T, c *db.Cache, _ *db.MemoryStore, oid int64) {
+				if err := c.UpdateOrder(oid, updated); err != nil {
+					t.Fatalf("UpdateOrder() error: %v", err)
+				}
+			},
+			track: "TRACK-new",
+		},
+		{
+			name: "purge",
+			change: func(t *testing.T, c *db.Cache, store *db.MemoryStore, oid int64) {
+				if err := store.UpdateOrder(oid, updated); err != nil {
+					t.Fatalf("UpdateOrder() error: %v", err)
+				}
+				c.Purge()
+			},
+			track: "TRACK-new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &racyStore{MemoryStore: db.NewMemoryStore()}
+			csh := testutil.NewCache(t, store, 10)
+			oid := testutil.AddOrders(t, csh, "a")["a"]
+			csh.Invalidate(oid)
+
+			store.onGet = func(oid int64) { tt.change(t, csh, store.MemoryStore, oid) }
+			if _, err := csh.GetOrderById(oid); err != nil {
+				t.Fatalf("GetOrderById() error: %v", err)
+			}
+			if size := csh.Stats().Size; (size == 1) != tt.cached {
+				t.Errorf("cache size = %d, loaded order cached: %v", size, tt.cached)
+			}
+			o, err := csh.GetOrderById(oid)
+			if err != nil {
+				t.Fatalf("GetOrderById() error: %v", err)
+			}
+			if o.TrackNumber != tt.track {
+				t.Errorf("TrackNumber = %q, want %q", o.TrackNumber, tt.track)
+			}
+		})
+	}
+}
+
+func TestCacheGetOrdersByIds(t *testing.T) {
+	csh, _ := newTestCache(t, 2)
+	oids := testutil.AddOrders(t, csh, "a", "b", "c")
+	// в кеше "b" и "c", "a" вытеснен
+
+	orders, err := csh.GetOrdersByIds([]int64{oids["a"], oids["b"], oids["c"]})
+	if err != nil {
+		t.Fatalf("GetOrdersByIds() error: %v", err)
+	}
+	if len(orders) != 3 || orders[oids["a"]].OrderUID != "a" {
+		t.Errorf("orders = %+v", orders)
+	}
+	// загруженный из БД Order не вытесняет закешированные
+	if got := csh.CachedUIDs(); !equalStrings(got, []string{"b", "c"}) {
+		t.Errorf("cached = %v, want [b c]", got)
+	}
+	if stats := csh.Stats(); stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v, want 2 hits, 1 miss and 1 eviction", stats)
+	}
+}
@@ -4,28 +4,52 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
+	"net/url"
+	"time"
+	"wb-test-task/internal/config"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // Инициализация пула соединений
-func (db *DB) Init() {
+func (db *DB) Init(cfg config.DBConfig) {
 	db.name = "Postgres"
 	var err error
-	dbUrl := fmt.Sprintf("postgres://%s:%s@%s/%s", os.Getenv("DB_USERNAME"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME"))
+	dbUrl := fmt.Sprintf("postgres://%s@%s/%s", url.UserPassword(cfg.Username, cfg.Password), cfg.Host, cfg.Name)
 
 	// создаем конфиг
 	config, err := pgxpool.ParseConfig(dbUrl)
 	if err != nil {
 		log.Fatalf("%v: Init() error: %s\n", db.name, err)
 	}
+	config.MaxConns = int32(cfg.PoolMaxConns)
+	config.MaxConnLifetime = time.Duration(cfg.PoolMaxConnLifetimeSecs) * time.Second
+
+	// Соединения устанавливаются по требованию: недоступность Postgres при старте не останавливает сервис,
+	// а отражается в /readyz
+	config.LazyConnect = true
 
 	db.pool, err = pgxpool.ConnectConfig(context.Background(), config)
-	//db.pool, err = pgxpool.Connect(context.Background(), dbUrl)
 	if err != nil {
-		//db.pool.Close()
-		log.Fatalf("%v: unable to connect to database: %v\n", db.name, err)
+		log.Fatalf("%v: unable to create connection pool: %v\n", db.name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.Ping(ctx); err != nil {
+		log.Printf("%v: warning: database is unavailable: %v\n", db.name, err)
+		return
 	}
 	log.Printf("%v: connected to database\n", db.name)
 }
+
+// Проверка доступности Postgres
+func (db *DB) Ping(ctx context.Context) error {
+	return db.pool.Ping(ctx)
+}
+
+// Закрытие пула соединений: ожидает возврата в пул всех используемых соединений
+func (db *DB) Close() {
+	db.pool.Close()
+	log.Printf("%v: connection pool closed\n", db.name)
+}
@@ -5,39 +5,52 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
+	"sort"
+	"strings"
+	"time"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/metrics"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
+var (
+	// Order с запрошенным id отсутствует в БД
+	ErrOrderNotFound = errors.New("order not found")
+	// Order с таким OrderUID уже сохранен (повторная доставка сообщения)
+	ErrOrderAlreadyExists = errors.New("order already stored")
+	// OrderUID при обновлении не совпадает с сохраненным
+	ErrOrderUIDMismatch = errors.New("order_uid can not be changed")
+	// В таблице cache нет сохраненных OrderID для приложения
+	ErrCacheEmpty = errors.New("cache is empty")
+	// Order обновлен, но уведомление остальных реплик не доставлено: оно повторяется в фоне
+	ErrInvalidationPending = errors.New("cache invalidation of other replicas is pending")
+)
+
 type DB struct {
 	pool *pgxpool.Pool
-	csh  *Cache
 	name string
 }
 
-func NewDB() *DB {
+func NewDB(cfg config.DBConfig) *DB {
 	db := DB{}
-	db.Init()
+	db.Init(cfg)
 	return &db
 }
 
-// Для обратных вызовов в кеш, сохраняем инстанс *Cache
-func (db *DB) SetCahceInstance(csh *Cache) {
-	db.csh = csh
-}
-
-// Загрузка объектов Orders (кеша) при его восстановлении
-func (db *DB) GetCacheState(bufSize int) (map[int64]Order, []int64, int, error) {
+// Загрузка объектов Orders (кеша приложения appKey) при его восстановлении. Возвращает список OrderID в порядке
+// добавления в кеш (от "старых" к "новым") и сами Order
+func (db *DB) GetCacheState(appKey string, bufSize int) ([]int64, map[int64]Order, error) {
 	buffer := make(map[int64]Order, bufSize)
-	queue := make([]int64, bufSize)
-	var queueInd int
+	queue := make([]int64, 0, bufSize)
 
-	// Выбираем все OrderID для нашей программы (APP_KEY) из таблицы кеша
-	query := fmt.Sprintf("SELECT order_id FROM cache WHERE app_key = '%s' ORDER BY id DESC LIMIT %d", os.Getenv("APP_KEY"), bufSize)
-	rows, err := db.pool.Query(context.Background(), query)
+	// Выбираем последние OrderID для нашей программы (APP_KEY) из таблицы кеша
+	rows, err := db.pool.Query(context.Background(), `SELECT order_id FROM cache WHERE app_key = $1 ORDER BY id DESC LIMIT $2`,
+		appKey, bufSize)
 	if err != nil {
 		log.Printf("%v: unable to get order_id from database: %v\n", db.name, err)
+		return queue, buffer, err
 	}
 	defer rows.Close()
 
@@ -46,88 +59,280 @@ func (db *DB) GetCacheState(bufSize int) (map[int64]Order, []int64, int, error)
 	for rows.Next() {
 		if err := rows.Scan(&oid); err != nil {
 			log.Printf("%v: unable to get oid from database row: %v\n", db.name, err)
-			return buffer, queue, queueInd, errors.New("unable to get oid from database row")
+			return queue, buffer, errors.New("unable to get oid from database row")
 		}
-		// сохраняем в очередь в порядке добавления в кеш (перед тем, как программа некоректно завершилась)
-		queue[queueInd] = oid
-		queueInd++
+		queue = append(queue, oid)
+	}
+	rows.Close()
 
-		o, err := db.GetOrderByID(oid)
-		if err != nil {
-			log.Printf("%v: unable to get order from database: %v\n", db.name, err)
-			continue
-		}
-		buffer[oid] = o
+	// Загрузка всех Order кеша одним пакетом
+	buffer, err = db.GetOrdersByIDs(queue)
+	if err != nil {
+		log.Printf("%v: unable to get orders from database: %v\n", db.name, err)
+		return queue, buffer, err
 	}
 
-	if queueInd == 0 {
-		return buffer, queue, queueInd, errors.New("cache is empty")
+	if len(queue) == 0 {
+		return queue, buffer, ErrCacheEmpty
 	}
 
 	// переиндексация - в начале queue - "старый" кеш, в конце очереди - "новый". После запроса (самого первого в этой функции) - наоборот
-	// Пример: после выполнения кода выше очередь содержит список Order ID: queue = [109 108 107 106 105 104 0 0 0 0],
+	// Пример: после выполнения кода выше очередь содержит список Order ID: queue = [109 108 107 106 105 104],
 	// Поскольку id=109 - это более "свежие" данные, то правильный порядок в очереди должен быть такой:
-	// queue = [104 105 106 107 108 109 0 0 0 0]
-	for i := 0; i < int(queueInd/2); i++ {
-		queue[i], queue[queueInd-i-1] = queue[queueInd-i-1], queue[i]
+	// queue = [104 105 106 107 108 109]
+	for i, j := 0, len(queue)-1; i < j; i, j = i+1, j-1 {
+		queue[i], queue[j] = queue[j], queue[i]
 	}
 
-	return buffer, queue, queueInd, nil
+	return queue, buffer, nil
 }
 
 // Получение Order из БД по id
 func (db *DB) GetOrderByID(oid int64) (Order, error) {
-	var o Order
-	var payment_id_fk int64
+	orders, err := db.GetOrdersByIDs([]int64{oid})
+	if err != nil {
+		return Order{}, err
+	}
+	o, ok := orders[oid]
+	if !ok {
+		return o, ErrOrderNotFound
+	}
+	return o, nil
+}
+
+// Получение нескольких Order из БД по списку id: по одному запросу к orders, payment и позициям заказов с товарами,
+// независимо от количества заказов. Отсутствующие в БД id в результат не попадают
+func (db *DB) GetOrdersByIDs(oids []int64) (map[int64]Order, error) {
+	orders := make(map[int64]Order, len(oids))
+	if len(oids) == 0 {
+		return orders, nil
+	}
 
-	// Сбор данных об Order
-	err := db.pool.QueryRow(context.Background(), `SELECT OrderUID, Entry, InternalSignature, payment_id_fk, Locale, CustomerID, 
-	TrackNumber, DeliveryService, Shardkey, SmID FROM orders WHERE id = $1`, oid).Scan(&o.OrderUID, &o.Entry,
-		&o.InternalSignature, &payment_id_fk, &o.Locale, &o.CustomerID, &o.TrackNumber, &o.DeliveryService, &o.Shardkey,
-		&o.SmID)
+	// Сбор данных об Orders
+	// delivery у заказов, сохраненных до появления таблицы, отсутствует - LEFT JOIN
+	rows, err := db.pool.Query(context.Background(), `SELECT o.id, o.OrderUID, o.Entry, o.InternalSignature, o.payment_id_fk,
+	o.Locale, o.CustomerID, o.TrackNumber, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, coalesce(o.OofShard, ''),
+	coalesce(d.Name, ''), coalesce(d.Phone, ''), coalesce(d.Zip, ''), coalesce(d.City, ''), coalesce(d.Address, ''),
+	coalesce(d.Region, ''), coalesce(d.Email, '')
+	FROM orders o LEFT JOIN delivery d ON d.id = o.delivery_id_fk WHERE o.id = ANY($1)`, oids)
 	if err != nil {
-		return o, errors.New("unable to get order from database")
+		log.Printf("%v: unable to get orders from database: %v\n", db.name, err)
+		return orders, errors.New("unable to get orders from database")
+	}
+	paymentOrders := make(map[int64][]int64, len(oids)) // payment_id_fk -> id Orders
+	paymentIds := make([]int64, 0, len(oids))
+	for rows.Next() {
+		var o Order
+		var oid, paymentIdFk int64
+		var dateCreated *time.Time
+		if err := rows.Scan(&oid, &o.OrderUID, &o.Entry, &o.InternalSignature, &paymentIdFk, &o.Locale, &o.CustomerID,
+			&o.TrackNumber, &o.DeliveryService, &o.Shardkey, &o.SmID, &dateCreated, &o.OofShard, &o.Delivery.Name,
+			&o.Delivery.Phone, &o.Delivery.Zip, &o.Delivery.City, &o.Delivery.Address, &o.Delivery.Region,
+			&o.Delivery.Email); err != nil {
+			rows.Close()
+			return orders, errors.New("unable to get order from database row")
+		}
+		if dateCreated != nil {
+			o.DateCreated = dateCreated.UTC()
+		}
+		orders[oid] = o
+		if _, ok := paymentOrders[paymentIdFk]; !ok {
+			paymentIds = append(paymentIds, paymentIdFk)
+		}
+		paymentOrders[paymentIdFk] = append(paymentOrders[paymentIdFk], oid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return orders, errors.New("unable to get orders from database")
 	}
 
-	// Сбор данных о Payment
-	err = db.pool.QueryRow(context.Background(), `SELECT Transaction, Currency, Provider, Amount, PaymentDt, Bank, DeliveryCost,
-	GoodsTotal FROM payment WHERE id = $1`, payment_id_fk).Scan(&o.Payment.Transaction, &o.Payment.Currency, &o.Payment.Provider,
-		&o.Payment.Amount, &o.Payment.PaymentDt, &o.Payment.Bank, &o.Payment.DeliveryCost, &o.Payment.GoodsTotal)
+	// Сбор данных о Payments
+	rows, err = db.pool.Query(context.Background(), `SELECT id, Transaction, Currency, Provider, Amount, PaymentDt, Bank,
+	DeliveryCost, GoodsTotal FROM payment WHERE id = ANY($1)`, paymentIds)
 	if err != nil {
 		log.Printf("%v: unable to get payment from database: %v\n", db.name, err)
-		return o, errors.New("unable to get payment from database")
+		return orders, errors.New("unable to get payment from database")
+	}
+	withPayment := make(map[int64]bool, len(orders))
+	for rows.Next() {
+		var p Payment
+		var paymentId int64
+		if err := rows.Scan(&paymentId, &p.Transaction, &p.Currency, &p.Provider, &p.Amount, &p.PaymentDt, &p.Bank,
+			&p.DeliveryCost, &p.GoodsTotal); err != nil {
+			rows.Close()
+			return orders, errors.New("unable to get payment from database row")
+		}
+		for _, oid := range paymentOrders[paymentId] {
+			o := orders[oid]
+			o.Payment = p
+			orders[oid] = o
+			withPayment[oid] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return orders, errors.New("unable to get payment from database")
 	}
 
-	// Сбор всех ItemsID для Order
-	rowsItems, err := db.pool.Query(context.Background(), "SELECT item_id_fk FROM order_items WHERE order_id_fk = $1", oid)
-	if err != nil {
-		return o, errors.New("unable to get items id list from database")
+	// Order без Payment считаем некорректным
+	for oid := range orders {
+		if !withPayment[oid] {
+			log.Printf("%v: unable to get payment from database for order %d\n", db.name, oid)
+			delete(orders, oid)
+		}
 	}
-	defer rowsItems.Close()
 
-	// Цикл по списку ItemsID
-	var itemID int64
-	for rowsItems.Next() {
+	// Сбор данных об Items всех Orders
+	rows, err = db.pool.Query(context.Background(), `SELECT oi.order_id_fk, p.ChrtID, oi.Price, oi.Rid, p.Name, oi.Sale, p.Size,
+	oi.TotalPrice, p.NmID, p.Brand FROM order_items oi JOIN products p ON p.id = oi.product_id_fk
+	WHERE oi.order_id_fk = ANY($1) ORDER BY oi.id`, oids)
+	if err != nil {
+		return orders, errors.New("unable to get items from database")
+	}
+	defer rows.Close()
+	for rows.Next() {
 		var item Items
-		if err := rowsItems.Scan(&itemID); err != nil {
-			return o, errors.New("unable to get itemID from database row")
+		var oid int64
+		if err := rows.Scan(&oid, &item.ChrtID, &item.Price, &item.Rid, &item.Name, &item.Sale, &item.Size,
+			&item.TotalPrice, &item.NmID, &item.Brand); err != nil {
+			return orders, errors.New("unable to get item from database row")
 		}
-		// Сбор данных об Items
-		err = db.pool.QueryRow(context.Background(), `SELECT ChrtID, Price, Rid, Name, Sale, Size, TotalPrice, NmID, Brand 
-		FROM items WHERE id = $1`, itemID).Scan(&item.ChrtID, &item.Price, &item.Rid, &item.Name, &item.Sale, &item.Size,
-			&item.TotalPrice, &item.NmID, &item.Brand)
-		if err != nil {
-			return o, errors.New("unable to get item from database")
+		o, ok := orders[oid]
+		if !ok {
+			continue
 		}
 		o.Items = append(o.Items, item)
+		orders[oid] = o
 	}
-	return o, nil
+	if err := rows.Err(); err != nil {
+		return orders, errors.New("unable to get items from database")
+	}
+	return orders, nil
+}
+
+// Список Order с фильтрами и курсорной пагинацией: от новых к старым, страница - не более filter.Limit заказов
+func (db *DB) ListOrders(filter OrderFilter) (OrderPage, error) {
+	var page OrderPage
+	var conds []string
+	var args []interface{}
+	addCond := func(cond string, value interface{}) {
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.CustomerID != "" {
+		addCond("o.CustomerID = $%d", filter.CustomerID)
+	}
+	if filter.DeliveryService != "" {
+		addCond("o.DeliveryService = $%d", filter.DeliveryService)
+	}
+	if filter.Locale != "" {
+		addCond("o.Locale = $%d", filter.Locale)
+	}
+	if filter.Currency != "" {
+		addCond("p.Currency = $%d", filter.Currency)
+	}
+	if filter.Provider != "" {
+		addCond("p.Provider = $%d", filter.Provider)
+	}
+	if filter.Bank != "" {
+		addCond("p.Bank = $%d", filter.Bank)
+	}
+	if filter.Brand != "" {
+		addCond(`EXISTS (SELECT 1 FROM order_items oi JOIN products pr ON pr.id = oi.product_id_fk
+		WHERE oi.order_id_fk = o.id AND pr.Brand = $%d)`, filter.Brand)
+	}
+	if filter.PaymentDtFrom != nil {
+		addCond("p.PaymentDt >= $%d", *filter.PaymentDtFrom)
+	}
+	if filter.PaymentDtTo != nil {
+		addCond("p.PaymentDt <= $%d", *filter.PaymentDtTo)
+	}
+	if filter.BeforeID > 0 {
+		addCond("o.id < $%d", filter.BeforeID)
+	}
+
+	query := "SELECT o.id FROM orders o JOIN payment p ON p.id = o.payment_id_fk"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// лишний Order в выборке показывает, что есть следующая страница
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" ORDER BY o.id DESC LIMIT $%d", len(args))
+
+	rows, err := db.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Printf("%v: unable to list orders: %v\n", db.name, err)
+		return page, errors.New("unable to list orders")
+	}
+	oids := make([]int64, 0, filter.Limit+1)
+	for rows.Next() {
+		var oid int64
+		if err := rows.Scan(&oid); err != nil {
+			rows.Close()
+			return page, errors.New("unable to get order id from database row")
+		}
+		oids = append(oids, oid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return page, errors.New("unable to list orders")
+	}
+
+	if len(oids) > filter.Limit {
+		oids = oids[:filter.Limit]
+		page.NextID = oids[len(oids)-1]
+	}
+	orders, err := db.GetOrdersByIDs(oids)
+	if err != nil {
+		return page, err
+	}
+	page.Orders = make([]OrderListItem, 0, len(oids))
+	for _, oid := range oids {
+		if o, ok := orders[oid]; ok {
+			page.Orders = append(page.Orders, OrderListItem{ID: oid, Order: o})
+		}
+	}
+	return page, nil
+}
+
+// Получение id Order по OrderUID (идентификатору из сообщения NATS)
+func (db *DB) GetOrderIDByUID(uid string) (int64, error) {
+	return db.getOrderIDBy(`SELECT id FROM orders WHERE OrderUID = $1 ORDER BY id DESC LIMIT 1`, uid)
+}
+
+// Получение id Order по TrackNumber. Если заказов с таким номером несколько - берется последний
+func (db *DB) GetOrderIDByTrackNumber(trackNumber string) (int64, error) {
+	return db.getOrderIDBy(`SELECT id FROM orders WHERE TrackNumber = $1 ORDER BY id DESC LIMIT 1`, trackNumber)
+}
+
+func (db *DB) getOrderIDBy(query string, value string) (int64, error) {
+	var oid int64
+	err := db.pool.QueryRow(context.Background(), query, value).Scan(&oid)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrOrderNotFound
+		}
+		log.Printf("%v: unable to get order id from database: %v\n", db.name, err)
+		return 0, errors.New("unable to get order id from database")
+	}
+	return oid, nil
 }
 
-// Сохранение Order в БД
+// Сохранение Order в БД. Если Order с таким OrderUID уже сохранен, новые строки не добавляются:
+// возвращается id существующего Order и ErrOrderAlreadyExists
 func (db *DB) AddOrder(o Order) (int64, error) {
+	start := time.Now()
+	oid, err := db.addOrder(o)
+	metrics.AddOrderDuration.Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrOrderAlreadyExists) {
+		metrics.AddOrderFailures.Inc()
+	}
+	return oid, err
+}
+
+func (db *DB) addOrder(o Order) (int64, error) {
 	var lastInsertId int64
-	var itemsIds []int64 = []int64{}
 
 	tx, err := db.pool.Begin(context.Background())
 	if err != nil {
@@ -135,16 +340,23 @@ func (db *DB) AddOrder(o Order) (int64, error) {
 	}
 	defer tx.Rollback(context.Background())
 
-	// добавление Items
-	for _, item := range o.Items {
-		err := tx.QueryRow(context.Background(), `INSERT INTO items (ChrtID, Price, Rid, Name, Sale, Size, TotalPrice, NmID, Brand)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`, item.ChrtID, item.Price, item.Rid, item.Name, item.Sale, item.Size,
-			item.TotalPrice, item.NmID, item.Brand).Scan(&lastInsertId)
-		if err != nil {
-			log.Printf("%v: unable to insert data (items): %v\n", db.name, err)
-			return -1, err
-		}
-		itemsIds = append(itemsIds, lastInsertId)
+	// Проверка на повторную доставку того же Order
+	var existingId int64
+	err = tx.QueryRow(context.Background(), `SELECT id FROM orders WHERE OrderUID = $1`, o.OrderUID).Scan(&existingId)
+	if err == nil {
+		log.Printf("%v: Order (uid:%s) already stored with id %d\n", db.name, o.OrderUID, existingId)
+		return existingId, ErrOrderAlreadyExists
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("%v: unable to check order uid: %v\n", db.name, err)
+		return -1, err
+	}
+
+	// Добавление товаров в каталог products (или обновление карточки существующего SKU)
+	productIds, err := upsertProducts(tx, o.Items)
+	if err != nil {
+		log.Printf("%v: unable to insert data (products): %v\n", db.name, err)
+		return -1, err
 	}
 
 	// Добавление Payment
@@ -157,51 +369,353 @@ func (db *DB) AddOrder(o Order) (int64, error) {
 	}
 	paymentIdFk := lastInsertId
 
+	// Добавление Delivery
+	err = tx.QueryRow(context.Background(), `INSERT INTO delivery (Name, Phone, Zip, City, Address, Region, Email)
+		values ($1, $2, $3, $4, $5, $6, $7) RETURNING id`, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City,
+		o.Delivery.Address, o.Delivery.Region, o.Delivery.Email).Scan(&lastInsertId)
+	if err != nil {
+		log.Printf("%v: unable to insert data (delivery): %v\n", db.name, err)
+		return -1, err
+	}
+	deliveryIdFk := lastInsertId
+
+	// Дата создания не передана - сохраняем NULL, а не нулевое время
+	var dateCreated *time.Time
+	if !o.DateCreated.IsZero() {
+		dateCreated = &o.DateCreated
+	}
+
 	// Добавление Order
-	err = tx.QueryRow(context.Background(), `INSERT INTO orders (OrderUID, Entry, InternalSignature, payment_id_fk, Locale, 
-		CustomerID, TrackNumber, DeliveryService, Shardkey, SmID) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		RETURNING id`,
-		o.OrderUID, o.Entry, o.InternalSignature, paymentIdFk, o.Locale, o.CustomerID, o.TrackNumber, o.DeliveryService,
-		o.Shardkey, o.SmID).Scan(&lastInsertId)
+	err = tx.QueryRow(context.Background(), `INSERT INTO orders (OrderUID, Entry, InternalSignature, payment_id_fk, delivery_id_fk,
+		Locale, CustomerID, TrackNumber, DeliveryService, Shardkey, SmID, DateCreated, OofShard)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (OrderUID) DO NOTHING RETURNING id`,
+		o.OrderUID, o.Entry, o.InternalSignature, paymentIdFk, deliveryIdFk, o.Locale, o.CustomerID, o.TrackNumber,
+		o.DeliveryService, o.Shardkey, o.SmID, dateCreated, o.OofShard).Scan(&lastInsertId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Order с тем же OrderUID сохранен параллельной транзакцией: откатываем вставку products, payment и delivery
+		log.Printf("%v: Order (uid:%s) was stored concurrently\n", db.name, o.OrderUID)
+		tx.Rollback(context.Background())
+		existingId, err = db.GetOrderIDByUID(o.OrderUID)
+		if err != nil {
+			return -1, err
+		}
+		return existingId, ErrOrderAlreadyExists
+	}
 	if err != nil {
 		log.Printf("%v: unable to insert data (orders): %v\n", db.name, err)
 		return -1, err
 	}
 	orderIdFk := lastInsertId
 
-	// Разрешение связей один-ко-многим для Order и Order.Items[]
-	for _, itemId := range itemsIds {
-		_, err := tx.Exec(context.Background(), `INSERT INTO order_items (order_id_fk, item_id_fk) values ($1, $2)`,
-			orderIdFk, itemId)
+	// Позиции заказа: ссылка на товар каталога и поля, относящиеся к этому заказу
+	for i, item := range o.Items {
+		_, err := tx.Exec(context.Background(), `INSERT INTO order_items (order_id_fk, product_id_fk, Price, Rid, Sale, TotalPrice)
+		values ($1, $2, $3, $4, $5, $6)`, orderIdFk, productIds[i], item.Price, item.Rid, item.Sale, item.TotalPrice)
 		if err != nil {
 			log.Printf("%v: unable to insert data (order_items): %v\n", db.name, err)
 			return -1, err
 		}
 	}
 
+	// Первая запись истории статусов (статус orders.Status по умолчанию - created)
+	_, err = tx.Exec(context.Background(), `INSERT INTO order_events (order_id_fk, Status, OccurredAt)
+		values ($1, $2, coalesce($3, now()))`, orderIdFk, StatusCreated, dateCreated)
+	if err != nil {
+		log.Printf("%v: unable to insert data (order_events): %v\n", db.name, err)
+		return -1, err
+	}
+
 	err = tx.Commit(context.Background())
 	if err != nil {
 		return 0, err
 	}
 
 	log.Printf("%v: Order successfull added to DB\n", db.name)
-	// После успешной записи добавляем в кеш
-	db.csh.SetOrder(orderIdFk, o)
 	return orderIdFk, nil
 }
 
+// Обновление сохраненного Order одной транзакцией: поля заказа, Payment, Delivery и позиции (заменяются целиком).
+// OrderUID изменить нельзя. Кеш обновляет вызывающий код (Cache.UpdateOrder)
+func (db *DB) UpdateOrder(oid int64, o Order) error {
+	tx, err := db.pool.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Блокировка строки заказа: параллельные обновления одного Order выполняются по очереди
+	var orderUID string
+	var paymentIdFk int64
+	var deliveryIdFk *int64
+	err = tx.QueryRow(context.Background(), `SELECT OrderUID, payment_id_fk, delivery_id_fk FROM orders WHERE id = $1 FOR UPDATE`,
+		oid).Scan(&orderUID, &paymentIdFk, &deliveryIdFk)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		log.Printf("%v: unable to get order for update: %v\n", db.name, err)
+		return err
+	}
+	if o.OrderUID != orderUID {
+		return ErrOrderUIDMismatch
+	}
+
+	_, err = tx.Exec(context.Background(), `UPDATE payment SET Transaction = $2, Currency = $3, Provider = $4, Amount = $5,
+		PaymentDt = $6, Bank = $7, DeliveryCost = $8, GoodsTotal = $9 WHERE id = $1`, paymentIdFk, o.Payment.Transaction,
+		o.Payment.Currency, o.Payment.Provider, o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank, o.Payment.DeliveryCost,
+		o.Payment.GoodsTotal)
+	if err != nil {
+		log.Printf("%v: unable to update data (payment): %v\n", db.name, err)
+		return err
+	}
+
+	// У заказов, сохраненных до появления таблицы delivery, строки нет - добавляем
+	if deliveryIdFk == nil {
+		deliveryIdFk = new(int64)
+		err = tx.QueryRow(context.Background(), `INSERT INTO delivery (Name, Phone, Zip, City, Address, Region, Email)
+			values ($1, $2, $3, $4, $5, $6, $7) RETURNING id`, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City,
+			o.Delivery.Address, o.Delivery.Region, o.Delivery.Email).Scan(deliveryIdFk)
+	} else {
+		_, err = tx.Exec(context.Background(), `UPDATE delivery SET Name = $2, Phone = $3, Zip = $4, City = $5, Address = $6,
+			Region = $7, Email = $8 WHERE id = $1`, *deliveryIdFk, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip,
+			o.Delivery.City, o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
+	}
+	if err != nil {
+		log.Printf("%v: unable to update data (delivery): %v\n", db.name, err)
+		return err
+	}
+
+	var dateCreated *time.Time
+	if !o.DateCreated.IsZero() {
+		dateCreated = &o.DateCreated
+	}
+	_, err = tx.Exec(context.Background(), `UPDATE orders SET Entry = $2, InternalSignature = $3, delivery_id_fk = $4, Locale = $5,
+		CustomerID = $6, TrackNumber = $7, DeliveryService = $8, Shardkey = $9, SmID = $10, DateCreated = $11, OofShard = $12
+		WHERE id = $1`, oid, o.Entry, o.InternalSignature, *deliveryIdFk, o.Locale, o.CustomerID, o.TrackNumber,
+		o.DeliveryService, o.Shardkey, o.SmID, dateCreated, o.OofShard)
+	if err != nil {
+		log.Printf("%v: unable to update data (orders): %v\n", db.name, err)
+		return err
+	}
+
+	// Позиции заказа заменяются целиком
+	if _, err := tx.Exec(context.Background(), `DELETE FROM order_items WHERE order_id_fk = $1`, oid); err != nil {
+		log.Printf("%v: unable to delete data (order_items): %v\n", db.name, err)
+		return err
+	}
+	productIds, err := upsertProducts(tx, o.Items)
+	if err != nil {
+		log.Printf("%v: unable to insert data (products): %v\n", db.name, err)
+		return err
+	}
+	for i, item := range o.Items {
+		_, err := tx.Exec(context.Background(), `INSERT INTO order_items (order_id_fk, product_id_fk, Price, Rid, Sale, TotalPrice)
+		values ($1, $2, $3, $4, $5, $6)`, oid, productIds[i], item.Price, item.Rid, item.Sale, item.TotalPrice)
+		if err != nil {
+			log.Printf("%v: unable to insert data (order_items): %v\n", db.name, err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return err
+	}
+	log.Printf("%v: Order (id:%d) successfull updated\n", db.name, oid)
+	return nil
+}
+
+// Применение события смены статуса: проверка перехода, запись в историю order_events и обновление orders.Status
+// одной транзакцией. Повторно доставленное событие (тот же EventID) возвращает ErrEventAlreadyApplied
+func (db *DB) ApplyStatusEvent(e StatusEvent) (OrderEvent, error) {
+	event := OrderEvent{EventID: e.EventID, Status: e.Status, OccurredAt: e.OccurredAt}
+
+	tx, err := db.pool.Begin(context.Background())
+	if err != nil {
+		return event, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// Блокировка строки заказа: события одного Order применяются по очереди
+	var oid int64
+	var status OrderStatus
+	err = tx.QueryRow(context.Background(), `SELECT id, Status FROM orders WHERE OrderUID = $1 FOR UPDATE`,
+		e.OrderUID).Scan(&oid, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return event, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Printf("%v: unable to get order status: %v\n", db.name, err)
+		return event, err
+	}
+
+	var applied bool
+	err = tx.QueryRow(context.Background(), `SELECT EXISTS (SELECT 1 FROM order_events WHERE EventID = $1)`,
+		e.EventID).Scan(&applied)
+	if err != nil {
+		log.Printf("%v: unable to check status event id: %v\n", db.name, err)
+		return event, err
+	}
+	if applied {
+		return event, ErrEventAlreadyApplied
+	}
+
+	if err := status.checkTransition(e.Status); err != nil {
+		return event, err
+	}
+	event.PrevStatus = status
+
+	err = tx.QueryRow(context.Background(), `INSERT INTO order_events (order_id_fk, EventID, Status, PrevStatus, OccurredAt)
+		values ($1, $2, $3, $4, $5) RETURNING RecordedAt`, oid, e.EventID, e.Status, status, e.OccurredAt).Scan(&event.RecordedAt)
+	if err != nil {
+		log.Printf("%v: unable to insert data (order_events): %v\n", db.name, err)
+		return event, err
+	}
+	if _, err := tx.Exec(context.Background(), `UPDATE orders SET Status = $2 WHERE id = $1`, oid, e.Status); err != nil {
+		log.Printf("%v: unable to update data (orders status): %v\n", db.name, err)
+		return event, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return event, err
+	}
+	event.RecordedAt = event.RecordedAt.UTC()
+	log.Printf("%v: Order (uid:%s) status changed: %s -> %s\n", db.name, e.OrderUID, status, e.Status)
+	return event, nil
+}
+
+// Текущий статус Order и история его изменений
+func (db *DB) GetOrderHistory(uid string) (OrderHistory, error) {
+	h := OrderHistory{OrderUID: uid, Events: []OrderEvent{}}
+
+	var oid int64
+	err := db.pool.QueryRow(context.Background(), `SELECT id, Status FROM orders WHERE OrderUID = $1`, uid).Scan(&oid, &h.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return h, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Printf("%v: unable to get order status: %v\n", db.name, err)
+		return h, errors.New("unable to get order status from database")
+	}
+
+	rows, err := db.pool.Query(context.Background(), `SELECT coalesce(EventID, ''), Status, coalesce(PrevStatus, ''), OccurredAt,
+	RecordedAt FROM order_events WHERE order_id_fk = $1 ORDER BY id`, oid)
+	if err != nil {
+		log.Printf("%v: unable to get order events: %v\n", db.name, err)
+		return h, errors.New("unable to get order events from database")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e OrderEvent
+		if err := rows.Scan(&e.EventID, &e.Status, &e.PrevStatus, &e.OccurredAt, &e.RecordedAt); err != nil {
+			return h, errors.New("unable to get order event from database row")
+		}
+		e.OccurredAt, e.RecordedAt = e.OccurredAt.UTC(), e.RecordedAt.UTC()
+		h.Events = append(h.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return h, errors.New("unable to get order events from database")
+	}
+	return h, nil
+}
+
+// Добавление товаров позиций заказа в каталог products. Карточка SKU (NmID, ChrtID) обновляется данными последнего заказа.
+// Возвращает id товара для каждой позиции, в порядке o.Items
+func upsertProducts(tx pgx.Tx, items []Items) ([]int64, error) {
+	// Блокировки строк каталога берутся в одном порядке, чтобы параллельные транзакции не попадали в deadlock
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		x, y := items[order[a]], items[order[b]]
+		return x.NmID < y.NmID || x.NmID == y.NmID && x.ChrtID < y.ChrtID
+	})
+
+	ids := make([]int64, len(items))
+	for _, i := range order {
+		item := items[i]
+		err := tx.QueryRow(context.Background(), `INSERT INTO products (NmID, ChrtID, Brand, Name, Size) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (NmID, ChrtID) DO UPDATE SET Brand = EXCLUDED.Brand, Name = EXCLUDED.Name, Size = EXCLUDED.Size
+		RETURNING id`, item.NmID, item.ChrtID, item.Brand, item.Name, item.Size).Scan(&ids[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Сохранение сообщения, которое не удалось обработать, в таблицу failed_messages
+func (db *DB) AddFailedMessage(fm FailedMessage) error {
+	_, err := db.pool.Exec(context.Background(), `INSERT INTO failed_messages (subject, sequence, redelivered, attempts, error,
+		data, failed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`, fm.Subject, int64(fm.Sequence), fm.Redelivered, fm.Attempts, fm.Error,
+		fm.Data, fm.FailedAt)
+	if err != nil {
+		log.Printf("%v: unable to insert data (failed_messages): %v\n", db.name, err)
+		return err
+	}
+	log.Printf("%v: failed message (seq:%d) successfull added to DB\n", db.name, fm.Sequence)
+	return nil
+}
+
 // Сохранение в таблицу Cache нового OrderID - нужно для восстановления кеша после сбоя (перед этим сохранили Order в БД и в кеш,
 // сохраняем теперь order_id в БД - таблица cahce)
-func (db *DB) SendOrderIDToCache(oid int64) {
-	db.pool.QueryRow(context.Background(), `INSERT INTO cache (order_id, app_key) VALUES ($1, $2)`, oid, os.Getenv("APP_KEY"))
+func (db *DB) SendOrderIDToCache(appKey string, oid int64) {
+	_, err := db.pool.Exec(context.Background(), `INSERT INTO cache (order_id, app_key) VALUES ($1, $2)`, oid, appKey)
+	if err != nil {
+		log.Printf("%v: unable to add OrderID %d to Cache (DB): %v\n", db.name, oid, err)
+		return
+	}
 	log.Printf("%v: OrderID successfull added to Cache (DB)\n", db.name)
 }
 
+// Замена списка OrderID кеша приложения appKey. oids - от наиболее востребованных к наименее:
+// вставляются в обратном порядке, чтобы GetCacheState (ORDER BY id DESC) вернул их первыми
+func (db *DB) ReplaceCache(appKey string, oids []int64) error {
+	tx, err := db.pool.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(context.Background(), `DELETE FROM cache WHERE app_key = $1`, appKey); err != nil {
+		log.Printf("%v: unable to replace cache: %v\n", db.name, err)
+		return err
+	}
+	reversed := make([]int64, len(oids))
+	for i, oid := range oids {
+		reversed[len(oids)-1-i] = oid
+	}
+	if _, err := tx.Exec(context.Background(), `INSERT INTO cache (order_id, app_key)
+		SELECT oid, $2 FROM unnest($1::int8[]) WITH ORDINALITY AS t(oid, n) ORDER BY n`, reversed, appKey); err != nil {
+		log.Printf("%v: unable to replace cache: %v\n", db.name, err)
+		return err
+	}
+	if err := tx.Commit(context.Background()); err != nil {
+		return err
+	}
+	log.Printf("%v: cache snapshot (%d orders) saved to database\n", db.name, len(oids))
+	return nil
+}
+
+// Удаление из таблицы cache строк приложения appKey, кроме keep последних: при восстановлении читаются только они.
+// Возвращает число удаленных строк
+func (db *DB) PruneCache(appKey string, keep int) (int64, error) {
+	tag, err := db.pool.Exec(context.Background(), `DELETE FROM cache WHERE app_key = $1 AND id NOT IN
+		(SELECT id FROM cache WHERE app_key = $1 ORDER BY id DESC LIMIT $2)`, appKey, keep)
+	if err != nil {
+		log.Printf("%v: unable to prune cache: %v\n", db.name, err)
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 // Очистка кеша из БД (таблица cache) при корректном завершении программы
-func (db *DB) ClearCache() {
-	_, err := db.pool.Exec(context.Background(), `DELETE FROM cache WHERE app_key = $1`, os.Getenv("APP_KEY"))
+func (db *DB) ClearCache(appKey string) {
+	_, err := db.pool.Exec(context.Background(), `DELETE FROM cache WHERE app_key = $1`, appKey)
 	if err != nil {
 		log.Printf("%v: clear cache error: %s\n", db.name, err)
+		return
 	}
 	log.Printf("%v: cache successfull cleared from database\n", db.name)
 }
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// Доступ к внутреннему состоянию кеша и хранилища для тестов пакета db_test
+
+// OrderUID закешированных Order в порядке сортировки
+func (c *Cache) CachedUIDs() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	uids := make([]string, 0, len(c.items))
+	for _, el := range c.items {
+		uids = append(uids, el.Value.(*cacheEntry).order.OrderUID)
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+// Вторичный индекс OrderUID: true, если Order, на который указывает индекс, есть в кеше
+func (c *Cache) UIDIndex() map[string]bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	uids := make(map[string]bool, len(c.uids))
+	for uid, oid := range c.uids {
+		_, ok := c.items[oid]
+		uids[uid] = ok
+	}
+	return uids
+}
+
+func (c *Cache) SetTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	c.ttl = ttl
+	c.mutex.Unlock()
+}
+
+// Истечение TTL закешированного Order
+func (c *Cache) Expire(oid int64) {
+	c.mutex.Lock()
+	c.items[oid].Value.(*cacheEntry).expiresAt = time.Now().Add(-time.Second)
+	c.mutex.Unlock()
+}
+
+func (ms *MemoryStore) DeleteOrder(oid int64) {
+	ms.mutex.Lock()
+	delete(ms.orders, oid)
+	ms.mutex.Unlock()
+}
@@ -0,0 +1,348 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Строка таблицы cache
+type memoryCacheRow struct {
+	oid    int64
+	appKey string
+}
+
+// Ключ товара в каталоге - SKU
+type productKey struct {
+	nmID   int
+	chrtID int
+}
+
+// Карточка товара каталога
+type product struct {
+	brand string
+	name  string
+	size  string
+}
+
+// Потокобезопасное хранилище Order в памяти с той же семантикой, что и DB
+type MemoryStore struct {
+	orders   map[int64]Order
+	uids     map[string]int64
+	products map[productKey]product // каталог товаров: карточки в Order.Items заменяются при чтении
+	events   map[int64][]OrderEvent // история статусов, последнее событие - текущий статус
+	eventIDs map[string]bool        // примененные события
+	lastID   int64
+	cache    []memoryCacheRow // в порядке добавления
+	failed   []FailedMessage
+	name     string
+	mutex    *sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	log.Printf("MemoryStore: orders are stored in memory and will be lost on exit\n")
+	return &MemoryStore{
+		orders:   make(map[int64]Order),
+		uids:     make(map[string]int64),
+		products: make(map[productKey]product),
+		events:   make(map[int64][]OrderEvent),
+		eventIDs: make(map[string]bool),
+		name:     "MemoryStore",
+		mutex:    &sync.RWMutex{},
+	}
+}
+
+// Сохранение Order. Если Order с таким OrderUID уже сохранен, возвращается его id и ErrOrderAlreadyExists
+func (ms *MemoryStore) AddOrder(o Order) (int64, error) {
+	ms.mutex.Lock()
+	if oid, ok := ms.uids[o.OrderUID]; ok {
+		ms.mutex.Unlock()
+		return oid, ErrOrderAlreadyExists
+	}
+	// Карточка SKU обновляется данными последнего заказа
+	for _, item := range o.Items {
+		ms.products[productKey{item.NmID, item.ChrtID}] = product{brand: item.Brand, name: item.Name, size: item.Size}
+	}
+	ms.lastID++
+	oid := ms.lastID
+	ms.orders[oid] = copyOrder(o)
+	ms.uids[o.OrderUID] = oid
+	now := time.Now().UTC()
+	created := OrderEvent{Status: StatusCreated, OccurredAt: o.DateCreated, RecordedAt: now}
+	if created.OccurredAt.IsZero() {
+		created.OccurredAt = now
+	}
+	ms.events[oid] = []OrderEvent{created}
+	ms.mutex.Unlock()
+
+	log.Printf("%v: Order successfull added to store\n", ms.name)
+	return oid, nil
+}
+
+// Обновление сохраненного Order. OrderUID изменить нельзя
+func (ms *MemoryStore) UpdateOrder(oid int64, o Order) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	stored, ok := ms.orders[oid]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	if stored.OrderUID != o.OrderUID {
+		return ErrOrderUIDMismatch
+	}
+	for _, item := range o.Items {
+		ms.products[productKey{item.NmID, item.ChrtID}] = product{brand: item.Brand, name: item.Name, size: item.Size}
+	}
+	ms.orders[oid] = copyOrder(o)
+	return nil
+}
+
+func (ms *MemoryStore) GetOrderByID(oid int64) (Order, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	o, ok := ms.orders[oid]
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	return ms.withProducts(o), nil
+}
+
+func (ms *MemoryStore) GetOrdersByIDs(oids []int64) (map[int64]Order, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	orders := make(map[int64]Order, len(oids))
+	for _, oid := range oids {
+		if o, ok := ms.orders[oid]; ok {
+			orders[oid] = ms.withProducts(o)
+		}
+	}
+	return orders, nil
+}
+
+func (ms *MemoryStore) GetOrderIDByUID(uid string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	oid, ok := ms.uids[uid]
+	if !ok {
+		return 0, ErrOrderNotFound
+	}
+	return oid, nil
+}
+
+// Последний Order с таким TrackNumber
+func (ms *MemoryStore) GetOrderIDByTrackNumber(trackNumber string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	var found int64
+	for oid, o := range ms.orders {
+		if o.TrackNumber == trackNumber && oid > found {
+			found = oid
+		}
+	}
+	if found == 0 {
+		return 0, ErrOrderNotFound
+	}
+	return found, nil
+}
+
+// Список Order с фильтрами и курсорной пагинацией, от новых к старым
+func (ms *MemoryStore) ListOrders(filter OrderFilter) (OrderPage, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	oids := make([]int64, 0, len(ms.orders))
+	for oid, o := range ms.orders {
+		if (filter.BeforeID == 0 || oid < filter.BeforeID) && filter.matches(ms.withProducts(o)) {
+			oids = append(oids, oid)
+		}
+	}
+	sort.Slice(oids, func(i, j int) bool { return oids[i] > oids[j] })
+
+	var page OrderPage
+	if len(oids) > filter.Limit {
+		oids = oids[:filter.Limit]
+		page.NextID = oids[len(oids)-1]
+	}
+	page.Orders = make([]OrderListItem, 0, len(oids))
+	for _, oid := range oids {
+		page.Orders = append(page.Orders, OrderListItem{ID: oid, Order: ms.withProducts(ms.orders[oid])})
+	}
+	return page, nil
+}
+
+func (ms *MemoryStore) AddFailedMessage(fm FailedMessage) error {
+	ms.mutex.Lock()
+	ms.failed = append(ms.failed, fm)
+	ms.mutex.Unlock()
+	return nil
+}
+
+func (ms *MemoryStore) ApplyStatusEvent(e StatusEvent) (OrderEvent, error) {
+	event := OrderEvent{EventID: e.EventID, Status: e.Status, OccurredAt: e.OccurredAt}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	oid, ok := ms.uids[e.OrderUID]
+	if !ok {
+		return event, ErrOrderNotFound
+	}
+	if ms.eventIDs[e.EventID] {
+		return event, ErrEventAlreadyApplied
+	}
+	events := ms.events[oid]
+	status := events[len(events)-1].Status
+	if err := status.checkTransition(e.Status); err != nil {
+		return event, err
+	}
+	event.PrevStatus = status
+	event.RecordedAt = time.Now().UTC()
+	ms.events[oid] = append(events, event)
+	ms.eventIDs[e.EventID] = true
+	return event, nil
+}
+
+func (ms *MemoryStore) GetOrderHistory(uid string) (OrderHistory, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	oid, ok := ms.uids[uid]
+	if !ok {
+		return OrderHistory{OrderUID: uid, Events: []OrderEvent{}}, ErrOrderNotFound
+	}
+	events := append([]OrderEvent(nil), ms.events[oid]...)
+	return OrderHistory{OrderUID: uid, Status: events[len(events)-1].Status, Events: events}, nil
+}
+
+// Последние bufSize OrderID кеша приложения appKey (от "старых" к "новым") и сами Order
+func (ms *MemoryStore) GetCacheState(appKey string, bufSize int) ([]int64, map[int64]Order, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	queue := make([]int64, 0, bufSize)
+	for i := len(ms.cache) - 1; i >= 0 && len(queue) < bufSize; i-- {
+		if ms.cache[i].appKey == appKey {
+			queue = append(queue, ms.cache[i].oid)
+		}
+	}
+	if len(queue) == 0 {
+		return queue, map[int64]Order{}, ErrCacheEmpty
+	}
+	for i, j := 0, len(queue)-1; i < j; i, j = i+1, j-1 {
+		queue[i], queue[j] = queue[j], queue[i]
+	}
+
+	buffer := make(map[int64]Order, len(queue))
+	for _, oid := range queue {
+		if o, ok := ms.orders[oid]; ok {
+			buffer[oid] = ms.withProducts(o)
+		}
+	}
+	return queue, buffer, nil
+}
+
+func (ms *MemoryStore) SendOrderIDToCache(appKey string, oid int64) {
+	ms.mutex.Lock()
+	ms.cache = append(ms.cache, memoryCacheRow{oid: oid, appKey: appKey})
+	ms.mutex.Unlock()
+}
+
+// Замена списка OrderID кеша: oids - от наиболее востребованных к наименее
+func (ms *MemoryStore) ReplaceCache(appKey string, oids []int64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	rows := ms.cache[:0]
+	for _, row := range ms.cache {
+		if row.appKey != appKey {
+			rows = append(rows, row)
+		}
+	}
+	for i := len(oids) - 1; i >= 0; i-- {
+		rows = append(rows, memoryCacheRow{oid: oids[i], appKey: appKey})
+	}
+	ms.cache = rows
+	return nil
+}
+
+// Удаление строк кеша appKey, кроме keep последних
+func (ms *MemoryStore) PruneCache(appKey string, keep int) (int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var seen int
+	var pruned int64
+	rows := make([]memoryCacheRow, 0, len(ms.cache))
+	for i := len(ms.cache) - 1; i >= 0; i-- {
+		row := ms.cache[i]
+		if row.appKey == appKey {
+			if seen++; seen > keep {
+				pruned++
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	ms.cache = rows
+	return pruned, nil
+}
+
+func (ms *MemoryStore) ClearCache(appKey string) {
+	ms.mutex.Lock()
+	rows := ms.cache[:0]
+	for _, row := range ms.cache {
+		if row.appKey != appKey {
+			rows = append(rows, row)
+		}
+	}
+	ms.cache = rows
+	ms.mutex.Unlock()
+}
+
+func (ms *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (ms *MemoryStore) Close() {}
+
+// Проверка Order на соответствие фильтру (без курсора и лимита)
+func (f OrderFilter) matches(o Order) bool {
+	if f.CustomerID != "" && o.CustomerID != f.CustomerID ||
+		f.DeliveryService != "" && o.DeliveryService != f.DeliveryService ||
+		f.Locale != "" && o.Locale != f.Locale ||
+		f.Currency != "" && o.Payment.Currency != f.Currency ||
+		f.Provider != "" && o.Payment.Provider != f.Provider ||
+		f.Bank != "" && o.Payment.Bank != f.Bank ||
+		f.PaymentDtFrom != nil && o.Payment.PaymentDt < *f.PaymentDtFrom ||
+		f.PaymentDtTo != nil && o.Payment.PaymentDt > *f.PaymentDtTo {
+		return false
+	}
+	if f.Brand == "" {
+		return true
+	}
+	for _, item := range o.Items {
+		if item.Brand == f.Brand {
+			return true
+		}
+	}
+	return false
+}
+
+// Копия Order с карточками товаров из каталога - как при чтении позиций заказа из БД. Вызывается под mutex
+func (ms *MemoryStore) withProducts(o Order) Order {
+	o = copyOrder(o)
+	for i, item := range o.Items {
+		if p, ok := ms.products[productKey{item.NmID, item.ChrtID}]; ok {
+			o.Items[i].Brand, o.Items[i].Name, o.Items[i].Size = p.brand, p.name, p.size
+		}
+	}
+	return o
+}
+
+// Копия Order, чтобы вызывающий код не мог изменить Items в хранилище
+func copyOrder(o Order) Order {
+	if o.Items != nil {
+		o.Items = append([]Items(nil), o.Items...)
+	}
+	return o
+}
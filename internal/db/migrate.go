@@ -0,0 +1,257 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Миграции схемы: migrations/NNNN_name.up.sql и migrations/NNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Ключ advisory lock, под которым применяются миграции: реплики, стартующие одновременно, ждут друг друга
+const migrationLockKey = 7240517023
+
+type Migration struct {
+	Version   int
+	Name      string
+	Up        string
+	Down      string
+	AppliedAt *time.Time // nil - миграция не применена
+}
+
+// Загрузка списка миграций, встроенных в бинарный файл, в порядке версий
+func loadMigrations() ([]Migration, error) {
+	return readMigrations(migrationFiles, "migrations")
+}
+
+// Чтение миграций из каталога dir файловой системы fsys
+func readMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		// 0001_init.up.sql -> версия 1, имя init, направление up
+		name := e.Name()
+		base := strings.TrimSuffix(name, ".sql")
+		dot := strings.LastIndex(base, ".")
+		underscore := strings.Index(base, "_")
+		if dot < 0 || underscore < 0 || underscore > dot {
+			return nil, fmt.Errorf("migration %s: unexpected file name", name)
+		}
+		version, err := strconv.Atoi(base[:underscore])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %v", name, err)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: base[underscore+1 : dot]}
+			byVersion[version] = m
+		}
+		switch base[dot+1:] {
+		case "up":
+			m.Up = string(data)
+		case "down":
+			m.Down = string(data)
+		default:
+			return nil, fmt.Errorf("migration %s: direction must be up or down", name)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s: up script is missing", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Выполнение fn в транзакции под advisory lock миграций. Таблица schema_migrations создается при необходимости
+func (db *DB) withMigrationLock(ctx context.Context, fn func(tx pgx.Tx, applied map[int]time.Time) error) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(migrationLockKey)); err != nil {
+		return fmt.Errorf("unable to acquire migration lock: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    int primary key,
+		name       varchar(256) not null,
+		applied_at timestamptz not null default now()
+	)`); err != nil {
+		return err
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := tx.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = appliedAt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := fn(tx, applied); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Применение всех непримененных миграций одной транзакцией. Возвращает число примененных миграций
+func (db *DB) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = db.withMigrationLock(ctx, func(tx pgx.Tx, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			log.Printf("%v: applying migration %04d_%s\n", db.name, m.Version, m.Name)
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("%v: %d migrations applied\n", db.name, count)
+	return count, nil
+}
+
+// Откат steps последних примененных миграций одной транзакцией
+func (db *DB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = db.withMigrationLock(ctx, func(tx pgx.Tx, applied map[int]time.Time) error {
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %04d_%s: down script is missing", m.Version, m.Name)
+			}
+			log.Printf("%v: reverting migration %04d_%s\n", db.name, m.Version, m.Name)
+			if _, err := tx.Exec(ctx, m.Down); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("%v: %d migrations reverted\n", db.name, count)
+	return count, nil
+}
+
+// Список всех миграций с отметкой о применении
+func (db *DB) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	err = db.withMigrationLock(ctx, func(tx pgx.Tx, applied map[int]time.Time) error {
+		for i := range migrations {
+			if appliedAt, ok := applied[migrations[i].Version]; ok {
+				migrations[i].AppliedAt = &appliedAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+// Выполнение подкоманды migrate: up, down [N], status
+func (db *DB) RunMigrateCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [N]|status")
+	}
+	switch args[0] {
+	case "up":
+		_, err := db.MigrateUp(ctx)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New("migrate down: N must be a positive integer")
+			}
+			steps = n
+		}
+		_, err := db.MigrateDown(ctx, steps)
+		return err
+	case "status":
+		migrations, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := "pending"
+			if m.AppliedAt != nil {
+				status = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("migrate: unknown command %q, expected up, down or status", args[0])
+	}
+}
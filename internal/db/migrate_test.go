@@ -0,0 +1,128 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadMigrations(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int    // версии в порядке применения
+		names    []string // имена миграций
+		err      string
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"m/0010_orders_index.up.sql":   file("CREATE INDEX"),
+				"m/0010_orders_index.down.sql": file("DROP INDEX"),
+				"m/0002_fix_keys.up.sql":       file("ALTER TABLE"),
+				"m/0001_init.up.sql":           file("CREATE TABLE"),
+				"m/0001_init.down.sql":         file("DROP TABLE"),
+			},
+			versions: []int{1, 2, 10},
+			names:    []string{"init", "fix_keys", "orders_index"},
+		},
+		{
+			name:     "underscores in name",
+			files:    fstest.MapFS{"m/0003_orders_uid_unique.up.sql": file("ALTER TABLE")},
+			versions: []int{3},
+			names:    []string{"orders_uid_unique"},
+		},
+		{
+			name:  "up script is missing",
+			files: fstest.MapFS{"m/0001_init.down.sql": file("DROP TABLE")},
+			err:   "0001_init: up script is missing",
+		},
+		{
+			name:  "no direction",
+			files: fstest.MapFS{"m/0001_init.sql": file("CREATE TABLE")},
+			err:   "unexpected file name",
+		},
+		{
+			name:  "bad version",
+			files: fstest.MapFS{"m/v1_init.up.sql": file("CREATE TABLE")},
+			err:   "bad version",
+		},
+		{
+			name:  "bad direction",
+			files: fstest.MapFS{"m/0001_init.apply.sql": file("CREATE TABLE")},
+			err:   "direction must be up or down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := readMigrations(tt.files, "m")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("readMigrations() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMigrations() error: %v", err)
+			}
+			if len(migrations) != len(tt.versions) {
+				t.Fatalf("got %d migrations, want %d", len(migrations), len(tt.versions))
+			}
+			for i, m := range migrations {
+				if m.Version != tt.versions[i] || m.Name != tt.names[i] {
+					t.Errorf("migration %d = %04d_%s, want %04d_%s", i, m.Version, m.Name, tt.versions[i], tt.names[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations() error: %v", err)
+	}
+	// версии идут подряд, у каждой миграции есть откат
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %04d_%s: version %d expected", m.Version, m.Name, i+1)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %04d_%s: down script is missing", m.Version, m.Name)
+		}
+	}
+
+	// строки, нарушающие ограничения, удаляются до создания или проверки ограничения
+	tests := []struct {
+		name       string
+		version    int
+		cleanup    string
+		constraint string
+	}{
+		{name: "duplicate orders before order_uid unique key", version: 1,
+			cleanup: "DELETE FROM public.orders", constraint: "ADD CONSTRAINT orders_orderuid_key"},
+		{name: "orphaned order items before order key validation", version: 2,
+			cleanup: "DELETE FROM order_items oi WHERE NOT EXISTS (SELECT 1 FROM orders", constraint: "VALIDATE CONSTRAINT order_id_fkey"},
+		{name: "missing payments before payment key validation", version: 2,
+			cleanup: "INSERT INTO payment (id)", constraint: "VALIDATE CONSTRAINT payment_id_fkey"},
+		{name: "order items without item before item key", version: 2,
+			cleanup: "DELETE FROM order_items oi WHERE NOT EXISTS (SELECT 1 FROM items", constraint: "ADD CONSTRAINT item_id_fkey"},
+		{name: "order items without product before not null", version: 3,
+			cleanup: "DELETE FROM order_items WHERE product_id_fk IS NULL", constraint: "product_id_fk SET NOT NULL"},
+		{name: "duplicate orders before order_uid unique key (0006)", version: 6,
+			cleanup: "DELETE FROM orders WHERE id IN", constraint: "ADD CONSTRAINT orders_orderuid_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.version > len(migrations) {
+				t.Fatalf("migration %04d not found", tt.version)
+			}
+			up := migrations[tt.version-1].Up
+			cleanup, constraint := strings.Index(up, tt.cleanup), strings.Index(up, tt.constraint)
+			if cleanup < 0 || constraint < 0 || cleanup > constraint {
+				t.Errorf("migration %04d: %q must precede %q", tt.version, tt.cleanup, tt.constraint)
+			}
+		})
+	}
+}
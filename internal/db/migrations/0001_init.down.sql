@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS failed_messages;
+DROP TABLE IF EXISTS cache;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS payment;
+DROP TABLE IF EXISTS items;
@@ -0,0 +1,100 @@
+-- Исходная схема (бывший dbScheme.sql). IF NOT EXISTS - чтобы миграция применилась и к базе,
+-- развернутой вручную из dbScheme.sql
+create table if not exists items (
+	id	bigserial not null primary key,
+	ChrtID     int,
+	Price      int,
+	Rid        varchar(256),
+	Name       varchar(128),
+	Sale       int,
+	Size       varchar(128),
+	TotalPrice int,
+	NmID       int,
+	Brand      varchar(128)
+);
+
+create table if not exists payment (
+	id	bigserial not null primary key,
+	Transaction  varchar(256),
+	Currency     varchar(128),
+	Provider     varchar(128),
+	Amount       int,
+	PaymentDt    int,
+	Bank         varchar(128),
+	DeliveryCost int,
+	GoodsTotal   int
+);
+
+create table if not exists "orders" (
+	id	bigserial not null primary key,
+	OrderUID          varchar(128),
+	Entry             varchar(128),
+	InternalSignature varchar(128),
+	payment_id_fk     bigserial,
+	Locale            varchar(128),
+	CustomerID        varchar(128),
+	TrackNumber       varchar(128),
+	DeliveryService   varchar(128),
+	Shardkey          varchar(128),
+	SmID              int,
+	totalprice        int
+);
+
+create table if not exists "order_items" (
+	id	bigserial not null primary key,
+	order_id_fk        bigserial,
+	item_id_fk         bigserial
+);
+
+create table if not exists "cache" (
+	id	bigserial not null primary key,
+	order_id	int8,
+	app_key        varchar(128)
+);
+
+create table if not exists "failed_messages" (
+	id	bigserial not null primary key,
+	subject	varchar(256),
+	sequence	int8,
+	redelivered	boolean,
+	attempts	int,
+	error	text,
+	data	bytea,
+	failed_at	timestamptz not null default now()
+);
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'payment_id_fkey') THEN
+		ALTER TABLE public.orders ADD CONSTRAINT payment_id_fkey FOREIGN KEY (payment_id_fk) REFERENCES public.payment(id) not valid;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'order_id_fkey') THEN
+		ALTER TABLE public.order_items ADD CONSTRAINT order_id_fkey FOREIGN KEY (order_id_fk) REFERENCES public.orders(id) not valid;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_orderuid_key') THEN
+		-- до проверки повторной доставки один Order мог быть сохранен несколько раз: оставляем первую
+		-- запись, остальные удаляем вместе с их позициями, товарами и payment
+		CREATE TEMP TABLE duplicate_orders ON COMMIT DROP AS
+		SELECT o.id, o.payment_id_fk
+		FROM public.orders o
+		JOIN (SELECT OrderUID, min(id) AS keep_id FROM public.orders WHERE OrderUID IS NOT NULL
+			GROUP BY OrderUID HAVING count(*) > 1) k ON k.OrderUID = o.OrderUID AND o.id <> k.keep_id;
+		CREATE TEMP TABLE duplicate_items ON COMMIT DROP AS
+		SELECT item_id_fk AS id FROM public.order_items WHERE order_id_fk IN (SELECT id FROM duplicate_orders);
+
+		DELETE FROM public.cache WHERE order_id IN (SELECT id FROM duplicate_orders);
+		DELETE FROM public.order_items WHERE order_id_fk IN (SELECT id FROM duplicate_orders);
+		DELETE FROM public.items i WHERE i.id IN (SELECT id FROM duplicate_items)
+			AND NOT EXISTS (SELECT 1 FROM public.order_items oi WHERE oi.item_id_fk = i.id);
+		DELETE FROM public.orders WHERE id IN (SELECT id FROM duplicate_orders);
+		DELETE FROM public.payment p WHERE p.id IN (SELECT payment_id_fk FROM duplicate_orders)
+			AND NOT EXISTS (SELECT 1 FROM public.orders o WHERE o.payment_id_fk = p.id);
+
+		ALTER TABLE public.orders ADD CONSTRAINT orders_orderuid_key UNIQUE (OrderUID);
+	END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS orders_customerid_idx ON public.orders (CustomerID);
+CREATE INDEX IF NOT EXISTS orders_deliveryservice_idx ON public.orders (DeliveryService);
+CREATE INDEX IF NOT EXISTS order_items_order_id_fk_idx ON public.order_items (order_id_fk);
+CREATE INDEX IF NOT EXISTS items_brand_idx ON public.items (Brand);
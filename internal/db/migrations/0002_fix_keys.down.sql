@@ -0,0 +1,2 @@
+ALTER TABLE orders ADD COLUMN totalprice int;
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS item_id_fkey;
@@ -0,0 +1,25 @@
+-- Внешние ключи - обычные bigint без собственных последовательностей
+ALTER TABLE orders ALTER COLUMN payment_id_fk DROP DEFAULT;
+ALTER TABLE order_items ALTER COLUMN order_id_fk DROP DEFAULT;
+ALTER TABLE order_items ALTER COLUMN item_id_fk DROP DEFAULT;
+DROP SEQUENCE IF EXISTS orders_payment_id_fk_seq;
+DROP SEQUENCE IF EXISTS order_items_order_id_fk_seq;
+DROP SEQUENCE IF EXISTS order_items_item_id_fk_seq;
+
+-- Строки, нарушающие ограничения, в базах, развернутых из dbScheme.sql: ограничения были not valid
+-- и не проверялись. Позиции без заказа или без товара удаляются, заказам без payment
+-- создается пустой payment с тем же id
+DELETE FROM order_items oi WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.id = oi.order_id_fk);
+DELETE FROM order_items oi WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.id = oi.item_id_fk);
+INSERT INTO payment (id)
+SELECT DISTINCT o.payment_id_fk FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM payment p WHERE p.id = o.payment_id_fk);
+SELECT setval(pg_get_serial_sequence('payment', 'id'), greatest((SELECT max(id) FROM payment), 1));
+
+-- Ограничения, созданные как not valid, проверяются для существующих строк
+ALTER TABLE orders VALIDATE CONSTRAINT payment_id_fkey;
+ALTER TABLE order_items VALIDATE CONSTRAINT order_id_fkey;
+ALTER TABLE order_items ADD CONSTRAINT item_id_fkey FOREIGN KEY (item_id_fk) REFERENCES items(id);
+
+-- Колонка никогда не заполнялась: итоговая сумма считается по items
+ALTER TABLE orders DROP COLUMN IF EXISTS totalprice;
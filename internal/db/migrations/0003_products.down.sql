@@ -0,0 +1,32 @@
+-- Обратно к копии товара на каждую позицию заказа
+create table items (
+	id	bigserial not null primary key,
+	ChrtID     int,
+	Price      int,
+	Rid        varchar(256),
+	Name       varchar(128),
+	Sale       int,
+	Size       varchar(128),
+	TotalPrice int,
+	NmID       int,
+	Brand      varchar(128),
+	order_item_id bigint
+);
+
+INSERT INTO items (ChrtID, Price, Rid, Name, Sale, Size, TotalPrice, NmID, Brand, order_item_id)
+SELECT p.ChrtID, oi.Price, oi.Rid, p.Name, oi.Sale, p.Size, oi.TotalPrice, p.NmID, p.Brand, oi.id
+FROM order_items oi JOIN products p ON p.id = oi.product_id_fk ORDER BY oi.id;
+
+ALTER TABLE order_items ADD COLUMN item_id_fk bigint;
+UPDATE order_items oi SET item_id_fk = i.id FROM items i WHERE i.order_item_id = oi.id;
+ALTER TABLE items DROP COLUMN order_item_id;
+ALTER TABLE order_items ADD CONSTRAINT item_id_fkey FOREIGN KEY (item_id_fk) REFERENCES items(id);
+
+ALTER TABLE order_items DROP COLUMN product_id_fk;
+ALTER TABLE order_items DROP COLUMN Price;
+ALTER TABLE order_items DROP COLUMN Rid;
+ALTER TABLE order_items DROP COLUMN Sale;
+ALTER TABLE order_items DROP COLUMN TotalPrice;
+DROP TABLE products;
+
+CREATE INDEX items_brand_idx ON items (Brand);
@@ -0,0 +1,37 @@
+-- Каталог товаров: карточка товара (SKU) хранится один раз и определяется парой NmID, ChrtID.
+-- В order_items остаются только поля позиции конкретного заказа
+create table products (
+	id	bigserial not null primary key,
+	NmID       int not null,
+	ChrtID     int not null,
+	Brand      varchar(128),
+	Name       varchar(128),
+	Size       varchar(128),
+	constraint products_nmid_chrtid_key unique (NmID, ChrtID)
+);
+
+-- Перенос существующих товаров: при расхождении карточек одного SKU берется самая поздняя
+INSERT INTO products (NmID, ChrtID, Brand, Name, Size)
+SELECT DISTINCT ON (coalesce(NmID, 0), coalesce(ChrtID, 0)) coalesce(NmID, 0), coalesce(ChrtID, 0), Brand, Name, Size
+FROM items ORDER BY coalesce(NmID, 0), coalesce(ChrtID, 0), id DESC;
+
+ALTER TABLE order_items ADD COLUMN product_id_fk bigint;
+ALTER TABLE order_items ADD COLUMN Price int;
+ALTER TABLE order_items ADD COLUMN Rid varchar(256);
+ALTER TABLE order_items ADD COLUMN Sale int;
+ALTER TABLE order_items ADD COLUMN TotalPrice int;
+
+UPDATE order_items oi SET product_id_fk = p.id, Price = i.Price, Rid = i.Rid, Sale = i.Sale, TotalPrice = i.TotalPrice
+FROM items i JOIN products p ON p.NmID = coalesce(i.NmID, 0) AND p.ChrtID = coalesce(i.ChrtID, 0)
+WHERE i.id = oi.item_id_fk;
+
+-- Позиции, для которых не нашлось товара, не могут быть перенесены
+DELETE FROM order_items WHERE product_id_fk IS NULL;
+ALTER TABLE order_items ALTER COLUMN product_id_fk SET NOT NULL;
+ALTER TABLE order_items ADD CONSTRAINT product_id_fkey FOREIGN KEY (product_id_fk) REFERENCES products(id);
+ALTER TABLE order_items DROP CONSTRAINT item_id_fkey;
+ALTER TABLE order_items DROP COLUMN item_id_fk;
+DROP TABLE items;
+
+CREATE INDEX products_brand_idx ON products (Brand);
+CREATE INDEX order_items_product_id_fk_idx ON order_items (product_id_fk);
@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN OofShard;
+ALTER TABLE orders DROP COLUMN DateCreated;
+ALTER TABLE orders DROP COLUMN delivery_id_fk;
+DROP TABLE delivery;
@@ -0,0 +1,16 @@
+-- Данные доставки заказа и поля date_created, oof_shard. У заказов, сохраненных ранее, они не заполнены
+create table delivery (
+	id	bigserial not null primary key,
+	Name    varchar(256),
+	Phone   varchar(64),
+	Zip     varchar(64),
+	City    varchar(256),
+	Address varchar(512),
+	Region  varchar(256),
+	Email   varchar(256)
+);
+
+ALTER TABLE orders ADD COLUMN delivery_id_fk bigint;
+ALTER TABLE orders ADD CONSTRAINT delivery_id_fkey FOREIGN KEY (delivery_id_fk) REFERENCES delivery(id);
+ALTER TABLE orders ADD COLUMN DateCreated timestamptz;
+ALTER TABLE orders ADD COLUMN OofShard varchar(128);
@@ -0,0 +1,2 @@
+DROP TABLE order_events;
+ALTER TABLE orders DROP COLUMN Status;
@@ -0,0 +1,18 @@
+-- Статус заказа и история его изменений. У заказов, сохраненных ранее, статус created
+ALTER TABLE orders ADD COLUMN Status varchar(32) NOT NULL DEFAULT 'created';
+
+create table order_events (
+	id          bigserial not null primary key,
+	order_id_fk bigint not null,
+	EventID     varchar(128) unique, -- NULL у события создания заказа
+	Status      varchar(32) not null,
+	PrevStatus  varchar(32),
+	OccurredAt  timestamptz not null,
+	RecordedAt  timestamptz not null default now(),
+	CONSTRAINT order_events_order_id_fkey FOREIGN KEY (order_id_fk) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+create index order_events_order_id_idx on order_events (order_id_fk, id);
+
+INSERT INTO order_events (order_id_fk, Status, OccurredAt)
+SELECT id, 'created', coalesce(DateCreated, now()) FROM orders;
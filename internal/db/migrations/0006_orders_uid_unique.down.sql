@@ -0,0 +1 @@
+-- Ограничение orders_orderuid_key создается в 0001_init и удаляется вместе с таблицей orders при его откате
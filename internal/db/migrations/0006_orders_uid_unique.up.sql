@@ -0,0 +1,23 @@
+-- Уникальность OrderUID для баз, где 0001_init был применен в редакции без ограничения orders_orderuid_key.
+-- Дубликаты удаляются так же, как в 0001_init, вместе с их позициями, payment и delivery.
+-- Там, где ограничение создано в 0001_init, дубликатов нет и миграция ничего не меняет
+CREATE TEMP TABLE duplicate_orders ON COMMIT DROP AS
+SELECT o.id, o.payment_id_fk, o.delivery_id_fk
+FROM orders o
+JOIN (SELECT OrderUID, min(id) AS keep_id FROM orders WHERE OrderUID IS NOT NULL
+	GROUP BY OrderUID HAVING count(*) > 1) k ON k.OrderUID = o.OrderUID AND o.id <> k.keep_id;
+
+DELETE FROM cache WHERE order_id IN (SELECT id FROM duplicate_orders);
+DELETE FROM order_items WHERE order_id_fk IN (SELECT id FROM duplicate_orders);
+DELETE FROM orders WHERE id IN (SELECT id FROM duplicate_orders); -- order_events удаляются каскадно
+DELETE FROM payment p WHERE p.id IN (SELECT payment_id_fk FROM duplicate_orders)
+	AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.payment_id_fk = p.id);
+DELETE FROM delivery d WHERE d.id IN (SELECT delivery_id_fk FROM duplicate_orders)
+	AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.delivery_id_fk = d.id);
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_orderuid_key') THEN
+		ALTER TABLE public.orders ADD CONSTRAINT orders_orderuid_key UNIQUE (OrderUID);
+	END IF;
+END $$;
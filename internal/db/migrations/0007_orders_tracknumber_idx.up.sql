@@ -0,0 +1,2 @@
+-- Поиск последнего Order по TrackNumber: номер не уникален, id берется из БД при каждом запросе
+CREATE INDEX orders_tracknumber_idx ON orders (TrackNumber, id);
@@ -1,18 +1,27 @@
 package db
 
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
 // Модель получаемых данных
 type Order struct {
-	OrderUID          string  `json:"order_uid"`
-	Entry             string  `json:"entry"`
-	InternalSignature string  `json:"internal_signature"`
-	Payment           Payment `json:"payment"`
-	Items             []Items `json:"items"`
-	Locale            string  `json:"locale"`
-	CustomerID        string  `json:"customer_id"`
-	TrackNumber       string  `json:"track_number"`
-	DeliveryService   string  `json:"delivery_service"`
-	Shardkey          string  `json:"shardkey"`
-	SmID              int     `json:"sm_id"`
+	OrderUID          string    `json:"order_uid"`
+	Entry             string    `json:"entry"`
+	InternalSignature string    `json:"internal_signature"`
+	Delivery          Delivery  `json:"delivery"`
+	Payment           Payment   `json:"payment"`
+	Items             []Items   `json:"items"`
+	Locale            string    `json:"locale"`
+	CustomerID        string    `json:"customer_id"`
+	TrackNumber       string    `json:"track_number"`
+	DeliveryService   string    `json:"delivery_service"`
+	Shardkey          string    `json:"shardkey"`
+	SmID              int       `json:"sm_id"`
+	DateCreated       time.Time `json:"date_created"`
+	OofShard          string    `json:"oof_shard"`
 }
 
 func (o *Order) GetTotalPrice() int {
@@ -23,6 +32,16 @@ func (o *Order) GetTotalPrice() int {
 	return total
 }
 
+type Delivery struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Zip     string `json:"zip"`
+	City    string `json:"city"`
+	Address string `json:"address"`
+	Region  string `json:"region"`
+	Email   string `json:"email"`
+}
+
 type Payment struct {
 	Transaction  string `json:"transaction"`
 	Currency     string `json:"currency"`
@@ -53,4 +72,103 @@ type OrderOut struct {
 	CustomerID      string `json:"customer_id"`
 	TrackNumber     string `json:"track_number"`
 	DeliveryService string `json:"delivery_service"`
+	// Персональные данные получателя в маскированном виде
+	Delivery    Delivery  `json:"delivery"`
+	DateCreated time.Time `json:"date_created"`
+	// Текущий статус и история статусов (заполняются отдельно, см. Cache.GetOrderHistory)
+	Status  OrderStatus  `json:"status,omitempty"`
+	History []OrderEvent `json:"history,omitempty"`
+}
+
+// Преобразование Order к модели для выдачи
+func NewOrderOut(o *Order) *OrderOut {
+	return &OrderOut{
+		OrderUID:        o.OrderUID,
+		Entry:           o.Entry,
+		TotalPrice:      o.GetTotalPrice(),
+		CustomerID:      o.CustomerID,
+		TrackNumber:     o.TrackNumber,
+		DeliveryService: o.DeliveryService,
+		Delivery:        o.Delivery.Masked(),
+		DateCreated:     o.DateCreated,
+	}
+}
+
+// Delivery со скрытыми персональными данными: у имени и адреса остаются первые буквы слов,
+// у телефона - код и две последние цифры, у email - первая буква и домен. Город, регион и индекс не маскируются
+func (d Delivery) Masked() Delivery {
+	d.Name = maskWords(d.Name)
+	d.Address = maskWords(d.Address)
+	d.Phone = maskPhone(d.Phone)
+	d.Email = maskEmail(d.Email)
+	return d
+}
+
+// "Test Testov" -> "T*** T*****"
+func maskWords(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		words[i] = string(r) + strings.Repeat("*", utf8.RuneCountInString(w[size:]))
+	}
+	return strings.Join(words, " ")
+}
+
+// "+9720000000" -> "+97******00"
+func maskPhone(s string) string {
+	runes := []rune(s)
+	if len(runes) <= 5 {
+		return strings.Repeat("*", len(runes))
+	}
+	for i := 3; i < len(runes)-2; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// "test@gmail.com" -> "t***@gmail.com"
+func maskEmail(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return maskWords(s)
+	}
+	return maskWords(s[:at]) + s[at:]
+}
+
+// Фильтр списка Order. Пустые поля не учитываются
+type OrderFilter struct {
+	CustomerID      string
+	DeliveryService string
+	Locale          string
+	Currency        string
+	Provider        string
+	Bank            string
+	Brand           string
+	PaymentDtFrom   *int  // payment_dt >= PaymentDtFrom
+	PaymentDtTo     *int  // payment_dt <= PaymentDtTo
+	BeforeID        int64 // курсор: id последнего Order предыдущей страницы (0 - первая страница)
+	Limit           int
+}
+
+// Order в списке вместе с его id
+type OrderListItem struct {
+	ID int64 `json:"id"`
+	Order
+}
+
+// Страница списка Order. NextID - курсор следующей страницы (0 - страница последняя)
+type OrderPage struct {
+	Orders []OrderListItem
+	NextID int64
+}
+
+// Сообщение NATS, которое не удалось обработать (dead letter)
+type FailedMessage struct {
+	Subject     string    `json:"subject"`
+	Sequence    uint64    `json:"sequence"`
+	Redelivered bool      `json:"redelivered"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error"`
+	Data        []byte    `json:"data"`
+	FailedAt    time.Time `json:"failed_at"`
 }
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"wb-test-task/internal/metrics"
+)
+
+// Формат файла снимка кеша:
+//
+//	"WBCS" | версия формата (1 байт) | sha256 данных (32 байта) | данные (gob)
+//
+// Снимок хранит только id Order и время истечения TTL в порядке использования: сами Order (в том числе
+// персональные данные получателя) на диск не пишутся и при загрузке читаются из хранилища.
+// Снимок другой версии формата или приложения (APP_KEY) считается устаревшим и не загружается
+const (
+	snapshotMagic   = "WBCS"
+	snapshotVersion = 2
+)
+
+var errSnapshotStale = errors.New("cache snapshot is stale")
+
+// Данные снимка: id Order кеша от последних использованных к давно не использованным
+type cacheSnapshot struct {
+	AppKey    string
+	CreatedAt time.Time
+	Entries   []snapshotEntry
+}
+
+type snapshotEntry struct {
+	OID       int64
+	ExpiresAt time.Time
+}
+
+// Запись снимка кеша в файл. Файл заменяется атомарно: сначала пишется временный файл, затем переименовывается
+func (c *Cache) saveSnapshot() error {
+	c.mutex.Lock()
+	snap := cacheSnapshot{AppKey: c.appKey, CreatedAt: time.Now(), Entries: make([]snapshotEntry, 0, c.recency.Len())}
+	for el := c.recency.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cacheEntry)
+		snap.Entries = append(snap.Entries, snapshotEntry{OID: entry.oid, ExpiresAt: entry.expiresAt})
+	}
+	c.mutex.Unlock()
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(snap); err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(payload.Bytes())
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.snapFile), filepath.Base(c.snapFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(snapshotMagic)
+	w.WriteByte(snapshotVersion)
+	w.Write(checksum[:])
+	w.Write(payload.Bytes())
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.snapFile); err != nil {
+		return err
+	}
+	log.Printf("%s: cache snapshot (%d orders) written to %s\n", c.name, len(snap.Entries), c.snapFile)
+	return nil
+}
+
+// Чтение и проверка файла снимка
+func readSnapshot(path string, appKey string) (cacheSnapshot, error) {
+	var snap cacheSnapshot
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+
+	headerSize := len(snapshotMagic) + 1 + sha256.Size
+	if len(data) < headerSize || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return snap, errors.New("not a cache snapshot file")
+	}
+	data = data[len(snapshotMagic):]
+	if data[0] != snapshotVersion {
+		return snap, fmt.Errorf("%w: format version %d, expected %d", errSnapshotStale, data[0], snapshotVersion)
+	}
+	data = data[1:]
+	checksum, payload := data[:sha256.Size], data[sha256.Size:]
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], checksum) {
+		return snap, errors.New("cache snapshot checksum mismatch")
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&snap); err != nil {
+		return snap, err
+	}
+	if snap.AppKey != appKey {
+		return snap, fmt.Errorf("%w: snapshot of application %s", errSnapshotStale, snap.AppKey)
+	}
+	return snap, nil
+}
+
+// Чтение файла снимка при старте. Возвращает false, если снимка нет или он отброшен -
+// тогда кеш восстанавливается из таблицы cache
+func (c *Cache) openSnapshot() (cacheSnapshot, bool) {
+	snap, err := readSnapshot(c.snapFile, c.appKey)
+	if os.IsNotExist(err) {
+		log.Printf("%s: cache snapshot %s not found\n", c.name, c.snapFile)
+		return snap, false
+	}
+	if err != nil {
+		log.Printf("%s: cache snapshot %s discarded: %v\n", c.name, c.snapFile, err)
+		return snap, false
+	}
+	return snap, true
+}
+
+// Восстановление кеша по снимку: Order из снимка загружаются из хранилища одним запросом и добавляются
+// в порядке использования, отсутствующие в хранилище пропускаются. Возвращает false, если хранилище
+// недоступно или Order изменились во время загрузки и ее нужно повторить
+func (c *Cache) loadSnapshot(snap cacheSnapshot) bool {
+	now := time.Now()
+	entries := make([]snapshotEntry, 0, len(snap.Entries))
+	oids := make([]int64, 0, len(snap.Entries))
+	for _, e := range snap.Entries {
+		if len(entries) >= c.bufSize {
+			break
+		}
+		if !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt) {
+			continue
+		}
+		entries = append(entries, e)
+		oids = append(oids, e.OID)
+	}
+	gen := c.generation()
+	orders, err := c.DBInst.GetOrdersByIDs(oids)
+	if err != nil {
+		log.Printf("%s: loadSnapshot() warning: can't load orders of snapshot %s: %v\n", c.name, c.snapFile, err)
+		return false
+	}
+
+	c.mutex.Lock()
+	if c.gen != gen {
+		c.mutex.Unlock()
+		log.Printf("%s: loadSnapshot(): orders changed while loading, retrying\n", c.name)
+		return false
+	}
+	for _, e := range entries {
+		o, ok := orders[e.OID]
+		if _, exists := c.items[e.OID]; !ok || exists || c.recency.Len() >= c.bufSize {
+			continue
+		}
+		c.items[e.OID] = c.recency.PushBack(&cacheEntry{oid: e.OID, order: o, expiresAt: e.ExpiresAt})
+		c.index(e.OID, o)
+	}
+	size := c.recency.Len()
+	c.mutex.Unlock()
+	metrics.CacheSize.Set(float64(size))
+	atomic.StoreInt32(&c.warm, 1)
+
+	log.Printf("%s: cache loaded from snapshot %s (created %s): %d orders, %d not found in storage\n", c.name,
+		c.snapFile, snap.CreatedAt.Format(time.RFC3339), size, len(entries)-len(orders))
+	return true
+}
+
+// Периодическая запись снимка кеша до завершения работы
+func (c *Cache) snapshotLoop() {
+	ticker := time.NewTicker(c.snapEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		// пока кеш не восстановлен, снимок затер бы предыдущий
+		if c.Ready() != nil {
+			continue
+		}
+		if err := c.saveSnapshot(); err != nil {
+			log.Printf("%s: snapshotLoop() warning: unable to write cache snapshot: %v\n", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, path string, store *db.MemoryStore, oids map[string]int64) // между запусками
+		appKey  string
+		size    int
+		cached  []string // пусто - снимок отброшен, кеш восстановлен из таблицы cache (очищенной политикой wipe)
+	}{
+		{
+			name:   "round trip",
+			cached: []string{"a", "b", "c"},
+		},
+		{
+			name:   "most recently used orders are loaded first",
+			size:   2,
+			cached: []string{"a", "c"},
+		},
+		{
+			name: "orders missing in storage are skipped",
+			prepare: func(t *testing.T, _ string, store *db.MemoryStore, oids map[string]int64) {
+				store.DeleteOrder(oids["b"])
+			},
+			cached: []string{"a", "c"},
+		},
+		{
+			name:    "not a snapshot file",
+			prepare: corruptSnapshot(func(data []byte) []byte { data[0] = 'X'; return data }),
+		},
+		{
+			name:    "other format version",
+			prepare: corruptSnapshot(func(data []byte) []byte { data[4] = 1; return data }),
+		},
+		{
+			name:    "checksum mismatch",
+			prepare: corruptSnapshot(func(data []byte) []byte { data[len(data)-1] ^= 0xff; return data }),
+		},
+		{
+			name:    "truncated file",
+			prepare: corruptSnapshot(func(data []byte) []byte { return data[:10] }),
+		},
+		{
+			name:   "snapshot of other application",
+			appKey: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.CacheConfig{
+				Size:                    10,
+				AppKey:                  "test",
+				ShutdownPolicy:          config.CacheShutdownWipe,
+				SnapshotFile:            filepath.Join(t.TempDir(), "cache.snapshot"),
+				SnapshotIntervalSeconds: 3600,
+			}
+			store := db.NewMemoryStore()
+			csh := db.NewCache(cfg, store)
+			oids := testutil.AddOrders(t, csh, "a", "b", "c")
+			// порядок использования: a, c, b
+			if _, err := csh.GetOrderById(oids["a"]); err != nil {
+				t.Fatalf("GetOrderById() error: %v", err)
+			}
+			csh.Finish()
+
+			if tt.prepare != nil {
+				tt.prepare(t, cfg.SnapshotFile, store, oids)
+			}
+			if tt.appKey != "" {
+				cfg.AppKey = tt.appKey
+			}
+			if tt.size != 0 {
+				cfg.Size = tt.size
+			}
+			csh = db.NewCache(cfg, store)
+			defer csh.Finish()
+			if err := csh.Ready(); err != nil {
+				t.Fatalf("cache is not ready: %v", err)
+			}
+			if got := csh.CachedUIDs(); !equalStrings(got, tt.cached) {
+				t.Errorf("cached = %v, want %v", got, tt.cached)
+			}
+		})
+	}
+}
+
+// Изменение содержимого файла снимка
+func corruptSnapshot(corrupt func(data []byte) []byte) func(*testing.T, string, *db.MemoryStore, map[string]int64) {
+	return func(t *testing.T, path string, _ *db.MemoryStore, _ map[string]int64) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() error: %v", err)
+		}
+		if err := ioutil.WriteFile(path, corrupt(data), 0o600); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+	}
+}
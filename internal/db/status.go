@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Статус заказа. Новый Order получает статус created, дальше статус меняется только событиями
+// из subject NATS_STATUS_SUBJECT по разрешенным переходам
+type OrderStatus string
+
+const (
+	StatusCreated   OrderStatus = "created"
+	StatusPaid      OrderStatus = "paid"
+	StatusAssembled OrderStatus = "assembled"
+	StatusShipped   OrderStatus = "shipped"
+	StatusDelivered OrderStatus = "delivered"
+	StatusCancelled OrderStatus = "cancelled"
+	StatusReturned  OrderStatus = "returned"
+)
+
+// Разрешенные переходы: отменить можно заказ, который еще не передан в доставку,
+// вернуть - переданный в доставку или доставленный. cancelled и returned - конечные статусы
+var statusTransitions = map[OrderStatus][]OrderStatus{
+	StatusCreated:   {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusAssembled, StatusCancelled},
+	StatusAssembled: {StatusShipped, StatusCancelled},
+	StatusShipped:   {StatusDelivered, StatusReturned},
+	StatusDelivered: {StatusReturned},
+	StatusCancelled: nil,
+	StatusReturned:  nil,
+}
+
+var (
+	// Неизвестный статус в событии
+	ErrInvalidStatus = errors.New("invalid order status")
+	// Переход из текущего статуса заказа в статус события не разрешен
+	ErrIllegalTransition = errors.New("illegal order status transition")
+	// Событие с таким EventID уже применено (повторная доставка сообщения)
+	ErrEventAlreadyApplied = errors.New("status event already applied")
+)
+
+func (s OrderStatus) Valid() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+// Разрешен ли переход из статуса s в статус next
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Проверка перехода. Ошибка содержит разрешенные из s статусы
+func (s OrderStatus) checkTransition(next OrderStatus) error {
+	if !next.Valid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, next)
+	}
+	if s.CanTransitionTo(next) {
+		return nil
+	}
+	allowed := make([]string, 0, len(statusTransitions[s]))
+	for _, st := range statusTransitions[s] {
+		allowed = append(allowed, string(st))
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("%w: %s -> %s (%s is final)", ErrIllegalTransition, s, next, s)
+	}
+	return fmt.Errorf("%w: %s -> %s (allowed: %s)", ErrIllegalTransition, s, next, strings.Join(allowed, ", "))
+}
+
+// Событие смены статуса заказа из NATS. EventID - идентификатор события у отправителя, по нему
+// отбрасываются повторные доставки
+type StatusEvent struct {
+	EventID    string      `json:"event_id"`
+	OrderUID   string      `json:"order_uid"`
+	Status     OrderStatus `json:"status"`
+	OccurredAt time.Time   `json:"occurred_at"`
+}
+
+// Проверка обязательных полей события
+func (e *StatusEvent) Validate() error {
+	var errs []string
+	if e.EventID == "" {
+		errs = append(errs, "event_id is required")
+	}
+	if e.OrderUID == "" {
+		errs = append(errs, "order_uid is required")
+	}
+	if !e.Status.Valid() {
+		errs = append(errs, fmt.Sprintf("unknown status %q", e.Status))
+	}
+	if e.Status == StatusCreated {
+		errs = append(errs, "status created is set on order creation")
+	}
+	if e.OccurredAt.IsZero() {
+		errs = append(errs, "occurred_at is required")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidStatus, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Запись истории статусов заказа (таблица order_events). У события создания заказа EventID и PrevStatus пустые
+type OrderEvent struct {
+	EventID    string      `json:"event_id,omitempty"`
+	Status     OrderStatus `json:"status"`
+	PrevStatus OrderStatus `json:"prev_status,omitempty"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	RecordedAt time.Time   `json:"recorded_at"`
+}
+
+// Текущий статус заказа и история его изменений от старых к новым
+type OrderHistory struct {
+	OrderUID string       `json:"order_uid"`
+	Status   OrderStatus  `json:"status"`
+	Events   []OrderEvent `json:"events"`
+}
@@ -0,0 +1,121 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+)
+
+func TestOrderStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to db.OrderStatus
+		allowed  bool
+	}{
+		{db.StatusCreated, db.StatusPaid, true},
+		{db.StatusCreated, db.StatusCancelled, true},
+		{db.StatusCreated, db.StatusShipped, false},
+		{db.StatusPaid, db.StatusAssembled, true},
+		{db.StatusPaid, db.StatusCreated, false},
+		{db.StatusAssembled, db.StatusShipped, true},
+		{db.StatusAssembled, db.StatusCancelled, true},
+		{db.StatusShipped, db.StatusDelivered, true},
+		{db.StatusShipped, db.StatusReturned, true},
+		{db.StatusShipped, db.StatusCancelled, false},
+		{db.StatusDelivered, db.StatusReturned, true},
+		{db.StatusDelivered, db.StatusShipped, false},
+		{db.StatusCancelled, db.StatusPaid, false},
+		{db.StatusReturned, db.StatusDelivered, false},
+		{db.StatusPaid, db.StatusPaid, false},
+		{db.StatusCreated, "lost", false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.allowed {
+				t.Errorf("CanTransitionTo() = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestStatusEventValidate(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name  string
+		event db.StatusEvent
+		valid bool
+	}{
+		{name: "valid", event: db.StatusEvent{EventID: "e1", OrderUID: "order-1", Status: db.StatusPaid, OccurredAt: now}, valid: true},
+		{name: "no event_id", event: db.StatusEvent{OrderUID: "order-1", Status: db.StatusPaid, OccurredAt: now}},
+		{name: "no order_uid", event: db.StatusEvent{EventID: "e1", Status: db.StatusPaid, OccurredAt: now}},
+		{name: "unknown status", event: db.StatusEvent{EventID: "e1", OrderUID: "order-1", Status: "lost", OccurredAt: now}},
+		{name: "created is not an event", event: db.StatusEvent{EventID: "e1", OrderUID: "order-1", Status: db.StatusCreated, OccurredAt: now}},
+		{name: "no occurred_at", event: db.StatusEvent{EventID: "e1", OrderUID: "order-1", Status: db.StatusPaid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.event.Validate()
+			if tt.valid != (err == nil) {
+				t.Fatalf("Validate() error = %v, want valid %v", err, tt.valid)
+			}
+			if err != nil && !errors.Is(err, db.ErrInvalidStatus) {
+				t.Errorf("Validate() error = %v, want ErrInvalidStatus", err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreApplyStatusEvent(t *testing.T) {
+	store := db.NewMemoryStore()
+	if _, err := store.AddOrder(testutil.Order("order-1")); err != nil {
+		t.Fatalf("AddOrder() error: %v", err)
+	}
+
+	// события применяются к одному Order по порядку
+	steps := []struct {
+		name    string
+		eventID string
+		uid     string
+		status  db.OrderStatus
+		err     error
+		current db.OrderStatus // статус после события
+	}{
+		{name: "paid", eventID: "e1", uid: "order-1", status: db.StatusPaid, current: db.StatusPaid},
+		{name: "redelivered event is skipped", eventID: "e1", uid: "order-1", status: db.StatusPaid, err: db.ErrEventAlreadyApplied, current: db.StatusPaid},
+		{name: "illegal transition", eventID: "e2", uid: "order-1", status: db.StatusDelivered, err: db.ErrIllegalTransition, current: db.StatusPaid},
+		{name: "unknown status", eventID: "e3", uid: "order-1", status: "lost", err: db.ErrInvalidStatus, current: db.StatusPaid},
+		{name: "unknown order", eventID: "e4", uid: "order-2", status: db.StatusPaid, err: db.ErrOrderNotFound, current: db.StatusPaid},
+		{name: "rejected event can be applied later", eventID: "e2", uid: "order-1", status: db.StatusAssembled, current: db.StatusAssembled},
+		{name: "cancelled", eventID: "e5", uid: "order-1", status: db.StatusCancelled, current: db.StatusCancelled},
+		{name: "cancelled is final", eventID: "e6", uid: "order-1", status: db.StatusPaid, err: db.ErrIllegalTransition, current: db.StatusCancelled},
+	}
+	for _, step := range steps {
+		e := db.StatusEvent{EventID: step.eventID, OrderUID: step.uid, Status: step.status, OccurredAt: time.Now().UTC()}
+		event, err := store.ApplyStatusEvent(e)
+		if !errors.Is(err, step.err) || (step.err == nil && err != nil) {
+			t.Fatalf("%s: ApplyStatusEvent() error = %v, want %v", step.name, err, step.err)
+		}
+		if err == nil && event.Status != step.status {
+			t.Errorf("%s: event = %+v", step.name, event)
+		}
+		history, err := store.GetOrderHistory("order-1")
+		if err != nil {
+			t.Fatalf("%s: GetOrderHistory() error: %v", step.name, err)
+		}
+		if history.Status != step.current {
+			t.Errorf("%s: status = %s, want %s", step.name, history.Status, step.current)
+		}
+	}
+
+	history, _ := store.GetOrderHistory("order-1")
+	want := []db.OrderStatus{db.StatusCreated, db.StatusPaid, db.StatusAssembled, db.StatusCancelled}
+	if len(history.Events) != len(want) {
+		t.Fatalf("history has %d events, want %d", len(history.Events), len(want))
+	}
+	for i, event := range history.Events {
+		if event.Status != want[i] || (i > 0 && event.PrevStatus != want[i-1]) {
+			t.Errorf("event %d = %+v, want %s", i, event, want[i])
+		}
+	}
+}
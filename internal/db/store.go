@@ -0,0 +1,35 @@
+package db
+
+import "context"
+
+// Хранилище Order. Реализации: DB (Postgres) и MemoryStore (в памяти, для разработки без Postgres и тестов)
+type OrderStore interface {
+	AddOrder(o Order) (int64, error)
+	UpdateOrder(oid int64, o Order) error
+	GetOrderByID(oid int64) (Order, error)
+	GetOrdersByIDs(oids []int64) (map[int64]Order, error)
+	GetOrderIDByUID(uid string) (int64, error)
+	GetOrderIDByTrackNumber(trackNumber string) (int64, error)
+	ListOrders(filter OrderFilter) (OrderPage, error)
+	AddFailedMessage(fm FailedMessage) error
+
+	// Статус Order и история его изменений
+	ApplyStatusEvent(e StatusEvent) (OrderEvent, error)
+	GetOrderHistory(uid string) (OrderHistory, error)
+
+	// Состояние кеша приложения appKey для восстановления после сбоя
+	GetCacheState(appKey string, bufSize int) ([]int64, map[int64]Order, error)
+	SendOrderIDToCache(appKey string, oid int64)
+	ReplaceCache(appKey string, oids []int64) error
+	PruneCache(appKey string, keep int) (int64, error)
+	ClearCache(appKey string)
+
+	Ping(ctx context.Context) error
+	// Освобождение ресурсов при завершении работы
+	Close()
+}
+
+var (
+	_ OrderStore = (*DB)(nil)
+	_ OrderStore = (*MemoryStore)(nil)
+)
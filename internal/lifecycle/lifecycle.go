@@ -0,0 +1,98 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Шаг остановки сервиса. ctx истекает по общему сроку завершения работы
+type step struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Менеджер жизненного цикла: по SIGTERM/SIGINT останавливает компоненты по очереди в порядке добавления,
+// укладываясь в общий срок. Повторный сигнал завершает процесс немедленно
+type Manager struct {
+	timeout time.Duration
+	steps   []step
+	name    string
+}
+
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{
+		name:    "Lifecycle",
+		timeout: timeout,
+	}
+}
+
+// Добавление шага остановки
+func (m *Manager) Add(name string, stop func(ctx context.Context) error) {
+	m.steps = append(m.steps, step{name: name, stop: stop})
+}
+
+// Ожидание сигнала и остановка компонентов. Возвращает ошибку, если какой-то шаг завершился с ошибкой
+// или срок завершения работы истек
+func (m *Manager) Wait() error {
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+
+	sig := <-signalChan
+	fmt.Printf("\nReceived %v, shutting down (timeout %v)...\n\n", sig, m.timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case sig := <-signalChan:
+			log.Printf("%s: received %v again, exiting immediately\n", m.name, sig)
+			os.Exit(1)
+		case <-stopped:
+		}
+	}()
+
+	return m.shutdown(ctx)
+}
+
+// Последовательное выполнение шагов. Если срок истек, оставшиеся шаги не выполняются:
+// зависший компонент не должен задерживать завершение процесса
+func (m *Manager) shutdown(ctx context.Context) error {
+	var errs []string
+	for _, s := range m.steps {
+		start := time.Now()
+		log.Printf("%s: stopping %s...\n", m.name, s.name)
+
+		done := make(chan error, 1)
+		go func(s step) { done <- s.stop(ctx) }(s)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("%s: %s stopped with error in %v: %v\n", m.name, s.name, time.Since(start), err)
+				errs = append(errs, s.name+": "+err.Error())
+				continue
+			}
+			log.Printf("%s: %s stopped in %v\n", m.name, s.name, time.Since(start))
+		case <-ctx.Done():
+			log.Printf("%s: shutdown deadline exceeded while stopping %s\n", m.name, s.name)
+			errs = append(errs, s.name+": "+ctx.Err().Error())
+			return errors.New("shutdown: " + strings.Join(errs, "; "))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("shutdown: " + strings.Join(errs, "; "))
+	}
+	log.Printf("%s: shutdown complete\n", m.name)
+	return nil
+}
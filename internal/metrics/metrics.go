@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Причины неуспешной обработки сообщения (метка reason)
+const (
+	ReasonInvalidJSON  = "invalid_json"
+	ReasonSchemaDrift  = "schema_drift"
+	ReasonInvalidOrder = "invalid_order"
+	ReasonStorage      = "storage"
+	ReasonDeadLetter   = "dead_letter"
+)
+
+// Обработка сообщений NATS в Subscriber
+var (
+	MessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_messages_received_total",
+		Help: "Order messages received from NATS Streaming.",
+	})
+	MessagesAcked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_messages_acked_total",
+		Help: "Order messages acknowledged to NATS Streaming.",
+	})
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_messages_failed_total",
+		Help: "Order messages that failed processing, by reason.",
+	}, []string{"reason"})
+	MessagesDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_messages_dead_lettered_total",
+		Help: "Order messages sent to the dead letter subject.",
+	})
+	SchemaDrift = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_messages_schema_drift_total",
+		Help: "Order message fields that do not match the model, by kind (unknown or missing) and field path (model fields only, \"other\" for unknown ones).",
+	}, []string{"field", "kind"})
+)
+
+// Результаты обработки событий смены статуса (метка result)
+const (
+	StatusApplied    = "applied"
+	StatusDuplicate  = "duplicate"
+	StatusInvalid    = "invalid"
+	StatusRetry      = "retry"
+	StatusDeadLetter = "dead_letter"
+)
+
+// Обработка событий смены статуса заказов
+var (
+	StatusEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_status_events_total",
+		Help: "Order status events received from NATS Streaming, by result (applied, duplicate, invalid, retry or dead_letter).",
+	}, []string{"result"})
+	StatusEventsAcked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_status_events_acked_total",
+		Help: "Order status events acknowledged to NATS Streaming.",
+	})
+	StatusTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_status_transitions_total",
+		Help: "Applied order status transitions, by new status.",
+	}, []string{"status"})
+)
+
+// Подключение к NATS Streaming
+var (
+	NATSConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orders_nats_connected",
+		Help: "1 if the NATS Streaming connection and subscription are up, 0 otherwise.",
+	})
+	NATSReconnectAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_nats_reconnect_attempts_total",
+		Help: "Attempts to re-establish the NATS Streaming connection, by result (ok or error).",
+	}, []string{"result"})
+)
+
+// Сохранение Order в Postgres
+var (
+	AddOrderDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "orders_db_add_order_duration_seconds",
+		Help:    "Latency of DB.AddOrder transactions.",
+		Buckets: prometheus.DefBuckets,
+	})
+	AddOrderFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_db_add_order_failures_total",
+		Help: "DB.AddOrder transactions that failed.",
+	})
+)
+
+// Кеш Order
+var (
+	CacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_cache_hits_total",
+		Help: "Order cache hits.",
+	})
+	CacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orders_cache_misses_total",
+		Help: "Order cache misses.",
+	})
+	CacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_cache_evictions_total",
+		Help: "Orders removed from the cache, by cause (lru, ttl or invalidation).",
+	}, []string{"cause"})
+	CacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orders_cache_size",
+		Help: "Orders currently held in the cache.",
+	})
+)
+
+// HTTP-сервер
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
@@ -0,0 +1,74 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+
+	stan "github.com/nats-io/stan.go"
+)
+
+type DeadLetter struct {
+	sc            *stan.Conn
+	dbObject      db.OrderStore
+	subject       string
+	maxRedelivery int
+	name          string
+}
+
+func NewDeadLetter(cfg config.NATSConfig, db db.OrderStore, conn *stan.Conn) *DeadLetter {
+	return &DeadLetter{
+		name:          "DeadLetter",
+		dbObject:      db,
+		sc:            conn,
+		subject:       cfg.DLQSubject,
+		maxRedelivery: cfg.MaxRedelivery,
+	}
+}
+
+// Исчерпан ли лимит попыток обработки сообщения
+func (dl *DeadLetter) Exhausted(attempts int) bool {
+	return attempts >= dl.maxRedelivery
+}
+
+// Отправка сообщения в dead letter: публикация в DLQ subject и сохранение в таблицу failed_messages.
+// Ошибка возвращается, только если сообщение не удалось сохранить ни одним из способов -
+// тогда его нельзя подтверждать, иначе оно будет потеряно
+func (dl *DeadLetter) Send(m *stan.Msg, attempts int, reason error) error {
+	fm := db.FailedMessage{
+		Subject:     m.Subject,
+		Sequence:    m.Sequence,
+		Redelivered: m.Redelivered,
+		Attempts:    attempts,
+		Error:       reason.Error(),
+		Data:        m.Data,
+		FailedAt:    time.Now(),
+	}
+	log.Printf("%s: sending msg (seq:%d, attempts:%d) to dead letter: %v\n", dl.name, fm.Sequence, fm.Attempts, reason)
+
+	pubErr := dl.publish(fm)
+	dbErr := dl.dbObject.AddFailedMessage(fm)
+	if pubErr != nil && dbErr != nil {
+		return errors.New("unable to publish and persist dead letter")
+	}
+	return nil
+}
+
+// Публикация сообщения с метаданными в DLQ subject
+func (dl *DeadLetter) publish(fm db.FailedMessage) error {
+	data, err := json.Marshal(fm)
+	if err != nil {
+		log.Printf("%s: json.Marshal error: %v\n", dl.name, err)
+		return err
+	}
+	err = (*dl.sc).Publish(dl.subject, data)
+	if err != nil {
+		log.Printf("%s: error publishing to %s: %v\n", dl.name, dl.subject, err)
+		return err
+	}
+	log.Printf("%s: msg (seq:%d) published to %s\n", dl.name, fm.Sequence, dl.subject)
+	return nil
+}
@@ -0,0 +1,168 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/metrics"
+)
+
+// Виды расхождения сообщения со схемой (метка kind)
+const (
+	driftUnknown = "unknown" // поле есть в сообщении, но отсутствует в модели
+	driftMissing = "missing" // поле модели отсутствует в сообщении
+)
+
+// Значение метки field для неизвестных полей: их имена задает источник, поэтому в метрику они не попадают
+// (только в лог и причину dead letter), иначе число временных рядов не ограничено
+const driftOtherField = "other"
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// Расхождения сообщения со схемой Order: пути полей вида delivery.phone, items[].rid
+type SchemaDriftError struct {
+	Unknown []string
+	Missing []string
+}
+
+func (e *SchemaDriftError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "unknown fields: "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing fields: "+strings.Join(e.Missing, ", "))
+	}
+	return "schema drift: " + strings.Join(parts, "; ")
+}
+
+// Разбор сообщений NATS в Order согласно политике NATS_DECODE_POLICY
+type Decoder struct {
+	policy string
+	name   string
+}
+
+func NewDecoder(cfg config.NATSConfig) *Decoder {
+	return &Decoder{
+		name:   "Decoder",
+		policy: cfg.DecodePolicy,
+	}
+}
+
+// Разбор сообщения. Ошибка *SchemaDriftError возвращается только в строгом режиме, ошибка формата JSON - в любом
+func (d *Decoder) Decode(data []byte) (db.Order, error) {
+	var o db.Order
+	if d.policy == config.DecodeLenient {
+		err := json.Unmarshal(data, &o)
+		return o, err
+	}
+
+	drift, err := schemaDrift(data, reflect.TypeOf(o))
+	if err != nil {
+		return o, err
+	}
+	if drift != nil {
+		for range drift.Unknown {
+			metrics.SchemaDrift.WithLabelValues(driftOtherField, driftUnknown).Inc()
+		}
+		for _, field := range drift.Missing {
+			metrics.SchemaDrift.WithLabelValues(field, driftMissing).Inc()
+		}
+		log.Printf("%s: %v\n", d.name, drift)
+		if d.policy == config.DecodeStrict {
+			return o, drift
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if d.policy == config.DecodeStrict {
+		dec.DisallowUnknownFields()
+	}
+	err = dec.Decode(&o)
+	return o, err
+}
+
+// Сравнение полей сообщения с полями модели t (по тегам json). Все поля модели считаются обязательными.
+// Возвращает nil, если расхождений нет
+func schemaDrift(data []byte, t reflect.Type) (*SchemaDriftError, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	unknown := make(map[string]bool)
+	missing := make(map[string]bool)
+	walkSchema(v, t, "", unknown, missing)
+	if len(unknown) == 0 && len(missing) == 0 {
+		return nil, nil
+	}
+	return &SchemaDriftError{Unknown: sortedKeys(unknown), Missing: sortedKeys(missing)}, nil
+}
+
+// Рекурсивный обход значения v параллельно с типом t. Несовпадение типов значений здесь не проверяется -
+// это ошибка разбора JSON
+func walkSchema(v interface{}, t reflect.Type, path string, unknown, missing map[string]bool) {
+	switch t.Kind() {
+	case reflect.Struct:
+		// типы со своим разбором JSON (time.Time) - листья схемы
+		if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+			return
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		known := make(map[string]bool, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			known[name] = true
+			child, ok := obj[name]
+			if !ok {
+				missing[path+name] = true
+				continue
+			}
+			walkSchema(child, t.Field(i).Type, path+name+".", unknown, missing)
+		}
+		for name := range obj {
+			if !known[name] {
+				unknown[path+name] = true
+			}
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		// items.rid -> items[].rid
+		path = strings.TrimSuffix(path, ".") + "[]."
+		for _, elem := range arr {
+			walkSchema(elem, t.Elem(), path, unknown, missing)
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Является ли ошибка разбора расхождением со схемой
+func isSchemaDrift(err error) bool {
+	var drift *SchemaDriftError
+	return errors.As(err, &drift)
+}
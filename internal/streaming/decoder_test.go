@@ -0,0 +1,102 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/testutil"
+)
+
+// Сообщение с Order, измененным функцией mutate на уровне JSON
+func orderMessage(t *testing.T, mutate func(msg map[string]interface{})) []byte {
+	t.Helper()
+	data, err := json.Marshal(testutil.Order("order-1"))
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("json.Unmarshal() error: %v", err)
+	}
+	mutate(msg)
+	if data, err = json.Marshal(msg); err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	return data
+}
+
+func TestDecoderPolicies(t *testing.T) {
+	valid := orderMessage(t, func(map[string]interface{}) {})
+	unknown := orderMessage(t, func(msg map[string]interface{}) {
+		msg["gift_wrap"] = true
+		msg["items"].([]interface{})[0].(map[string]interface{})["color"] = "red"
+	})
+	missing := orderMessage(t, func(msg map[string]interface{}) {
+		delete(msg["delivery"].(map[string]interface{}), "phone")
+		delete(msg, "locale")
+	})
+	invalid := []byte(`{"order_uid":`)
+
+	policies := []string{config.DecodeLenient, config.DecodeWarn, config.DecodeStrict}
+	tests := []struct {
+		name string
+		data []byte
+		// ожидаемая ошибка для каждой политики из policies: "" - сообщение разобрано
+		errs    [3]string
+		unknown []string // поля SchemaDriftError в строгом режиме
+		missing []string
+	}{
+		{
+			name: "valid order",
+			data: valid,
+		},
+		{
+			name:    "unknown fields",
+			data:    unknown,
+			errs:    [3]string{"", "", "unknown fields: gift_wrap, items[].color"},
+			unknown: []string{"gift_wrap", "items[].color"},
+		},
+		{
+			name:    "missing fields",
+			data:    missing,
+			errs:    [3]string{"", "", "missing fields: delivery.phone, locale"},
+			missing: []string{"delivery.phone", "locale"},
+		},
+		{
+			name: "invalid json",
+			data: invalid,
+			errs: [3]string{"unexpected end of JSON input", "unexpected end of JSON input", "unexpected end of JSON input"},
+		},
+	}
+	for _, tt := range tests {
+		for i, policy := range policies {
+			t.Run(tt.name+"/"+policy, func(t *testing.T) {
+				d := NewDecoder(config.NATSConfig{DecodePolicy: policy})
+				o, err := d.Decode(tt.data)
+				if tt.errs[i] == "" {
+					if err != nil {
+						t.Fatalf("Decode() error: %v", err)
+					}
+					if o.OrderUID != "order-1" {
+						t.Errorf("OrderUID = %q, want order-1", o.OrderUID)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.errs[i]) {
+					t.Fatalf("Decode() error = %v, want %q", err, tt.errs[i])
+				}
+
+				var drift *SchemaDriftError
+				if isDrift := errors.As(err, &drift); isDrift != (tt.unknown != nil || tt.missing != nil) {
+					t.Fatalf("schema drift = %v for error %v", isDrift, err)
+				}
+				if drift != nil && (strings.Join(drift.Unknown, ",") != strings.Join(tt.unknown, ",") ||
+					strings.Join(drift.Missing, ",") != strings.Join(tt.missing, ",")) {
+					t.Errorf("drift = %+v, want unknown %v, missing %v", drift, tt.unknown, tt.missing)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package streaming
+
+import (
+	"log"
+
+	stand "github.com/nats-io/nats-streaming-server/server"
+)
+
+// Встроенный NATS Streaming сервер для локальной разработки и тестов (режим NATS_EMBEDDED)
+type EmbeddedServer struct {
+	srv  *stand.StanServer
+	name string
+}
+
+// Запуск встроенного сервера с хранилищем в памяти на случайном свободном порту
+func NewEmbeddedServer(clusterID string) (*EmbeddedServer, error) {
+	es := EmbeddedServer{name: "EmbeddedNATS"}
+
+	opts := stand.GetDefaultOptions()
+	opts.ID = clusterID
+	natsOpts := stand.DefaultNatsServerOptions
+	natsOpts.Port = -1 // случайный свободный порт
+
+	srv, err := stand.RunServerWithOpts(opts, &natsOpts)
+	if err != nil {
+		log.Printf("%s: unable to start server: %v\n", es.name, err)
+		return nil, err
+	}
+	es.srv = srv
+	log.Printf("%s: cluster %s started at %s\n", es.name, clusterID, es.URL())
+	return &es, nil
+}
+
+// Адрес для подключения клиентов
+func (es *EmbeddedServer) URL() string {
+	return es.srv.ClientURL()
+}
+
+func (es *EmbeddedServer) Shutdown() {
+	log.Printf("%s: shutdown...\n", es.name)
+	es.srv.Shutdown()
+}
@@ -1,52 +1,92 @@
 package streaming
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"sync"
 	"time"
+	"wb-test-task/internal/config"
 	"wb-test-task/internal/db"
+	"wb-test-task/internal/metrics"
 
 	"github.com/nats-io/nats.go"
 	stan "github.com/nats-io/stan.go"
 )
 
 type StreamingHandler struct {
-	conn  *stan.Conn
-	sub   *Subscriber
-	pub   *Publisher
-	name  string
-	isErr bool
+	cfg        config.NATSConfig
+	conn       *stan.Conn
+	sub        *Subscriber
+	statusSub  *Subscriber        // подписка на события смены статуса заказов
+	invSub     *nats.Subscription // подписка на инвалидации кеша от других реплик
+	embedded   *EmbeddedServer
+	dbObject   db.OrderStore
+	csh        *db.Cache
+	name       string
+	isErr      bool
+	connected  bool       // соединение установлено и не потеряно
+	lostErr    error      // причина потери соединения
+	attempt    int        // номер текущей попытки переподключения, 0 - переподключение не идет
+	gen        int        // номер соединения: уведомления о потере старых соединений игнорируются
+	lost       chan error // уведомления супервизора о потере соединения
+	done       chan struct{}
+	finished   bool
+	subscribed bool // подписка уже создавалась: последующие подключения - переподключения
+	mutex      *sync.RWMutex
 }
 
-func NewStreamingHandler(db *db.DB) *StreamingHandler {
+func NewStreamingHandler(cfg config.NATSConfig, db db.OrderStore, csh *db.Cache) *StreamingHandler {
 	sh := StreamingHandler{}
-	sh.Init(db)
+	sh.Init(cfg, db, csh)
 	return &sh
 }
 
-// Инициализация Subscriber и Publisher
-func (sh *StreamingHandler) Init(db *db.DB) {
+// Инициализация подключения и подписок
+func (sh *StreamingHandler) Init(cfg config.NATSConfig, db db.OrderStore, csh *db.Cache) {
 	sh.name = "StreamingHandler"
-	err := sh.Connect()
+	sh.cfg = cfg
+	sh.dbObject = db
+	sh.csh = csh
+	sh.mutex = &sync.RWMutex{}
+	sh.lost = make(chan error, 1)
+	sh.done = make(chan struct{})
 
+	// Режим разработки: встроенный NATS Streaming сервер вместо внешнего кластера
+	if cfg.Embedded {
+		embedded, err := NewEmbeddedServer(cfg.ClusterID)
+		if err != nil {
+			sh.isErr = true
+			sh.lostErr = err
+			log.Printf("%s: StreamingHandler error: %s", sh.name, err)
+			return
+		}
+		sh.embedded = embedded
+		sh.cfg.Hosts = embedded.URL()
+	}
+
+	// Супервизор восстанавливает соединение и подписку, в том числе если NATS недоступен при старте
+	go sh.supervise()
+
+	err := sh.connectAndSubscribe()
 	if err != nil {
-		sh.isErr = true
 		log.Printf("%s: StreamingHandler error: %s", sh.name, err)
-	} else {
-		sh.sub = NewSubscriber(db, sh.conn)
-		sh.sub.Subscribe()
-
-		sh.pub = NewPublisher(sh.conn)
-		sh.pub.Publish()
+		sh.notifyLost(err)
 	}
 }
 
 // Подключение к NATS
 func (sh *StreamingHandler) Connect() error {
+	sh.mutex.Lock()
+	sh.gen++
+	gen := sh.gen
+	sh.mutex.Unlock()
+
 	conn, err := stan.Connect(
-		os.Getenv("NATS_CLUSTER_ID"),
-		os.Getenv("NATS_CLIENT_ID"),
-		stan.NatsURL(os.Getenv("NATS_HOSTS")),
+		sh.cfg.ClusterID,
+		sh.cfg.ClientID,
+		stan.NatsURL(sh.cfg.Hosts),
 		stan.NatsOptions(
 			nats.ReconnectWait(time.Second*4),
 			nats.Timeout(time.Second*4),
@@ -54,24 +94,156 @@ func (sh *StreamingHandler) Connect() error {
 		stan.Pings(5, 3), // Send PINGs every 5 seconds, and fail after 3 PINGs without any response.
 		stan.SetConnectionLostHandler(func(_ stan.Conn, reason error) {
 			log.Printf("%s: connection lost, reason: %v", sh.name, reason)
+			sh.connectionLost(gen, reason)
 		}),
 	)
 	if err != nil {
 		log.Printf("%s: can't connect: %v.\n", sh.name, err)
 		return err
 	}
+
+	sh.mutex.Lock()
 	sh.conn = &conn
+	sh.mutex.Unlock()
 
 	log.Printf("%s: connected!", sh.name)
 	return nil
 }
 
-// Завершение работы с NATS
-func (sh *StreamingHandler) Finish() {
+// Подключение и создание долговечной подписки: после переподключения доставка продолжается
+// с последнего подтвержденного сообщения (durable name / queue group)
+func (sh *StreamingHandler) connectAndSubscribe() error {
+	if err := sh.Connect(); err != nil {
+		return err
+	}
+
+	sh.mutex.RLock()
+	conn := sh.conn
+	sh.mutex.RUnlock()
+
+	invSub, err := sh.subscribeInvalidations(conn)
+	if err != nil {
+		(*conn).Close()
+		return err
+	}
+	dl := NewDeadLetter(sh.cfg, sh.dbObject, conn)
+	sub := NewSubscriber(sh.cfg, sh.dbObject, sh.csh, conn, dl)
+	if err := sub.Subscribe(); err != nil {
+		(*conn).Close()
+		return err
+	}
+	statusSub := NewStatusSubscriber(sh.cfg, sh.dbObject, conn, dl)
+	if err := statusSub.Subscribe(); err != nil {
+		sub.Close()
+		(*conn).Close()
+		return err
+	}
+
+	sh.mutex.Lock()
+	if sh.finished {
+		// Finish() вызван во время подключения: новое соединение никому не нужно
+		sh.mutex.Unlock()
+		sub.Close()
+		statusSub.Close()
+		(*conn).Close()
+		return errors.New("streaming handler is finished")
+	}
+	reconnected := sh.subscribed
+	sh.sub = sub
+	sh.statusSub = statusSub
+	sh.invSub = invSub
+	sh.subscribed = true
+	sh.connected = true
+	sh.lostErr = nil
+	sh.attempt = 0
+	sh.mutex.Unlock()
+	metrics.NATSConnected.Set(1)
+
+	// Пока соединения не было, уведомления об изменениях Order могли быть пропущены
+	if reconnected {
+		sh.csh.Purge()
+	}
+	return nil
+}
+
+// Уведомление о потере соединения номер gen
+func (sh *StreamingHandler) connectionLost(gen int, reason error) {
+	sh.mutex.Lock()
+	if gen != sh.gen || sh.finished {
+		sh.mutex.Unlock()
+		return
+	}
+	sh.connected = false
+	sh.lostErr = reason
+	sh.mutex.Unlock()
+	metrics.NATSConnected.Set(0)
+	sh.notifyLost(reason)
+}
+
+// Передача причины потери соединения супервизору. Если он уже переподключается - уведомление не нужно
+func (sh *StreamingHandler) notifyLost(reason error) {
+	select {
+	case sh.lost <- reason:
+	default:
+	}
+}
+
+// Состояние подключения к NATS Streaming и подписки для /readyz
+func (sh *StreamingHandler) Health() error {
+	sh.mutex.RLock()
+	connected, lostErr, attempt, sub, statusSub := sh.connected, sh.lostErr, sh.attempt, sh.sub, sh.statusSub
+	sh.mutex.RUnlock()
+
+	if !connected {
+		if attempt > 0 {
+			return fmt.Errorf("reconnecting (attempt %d): %v", attempt, lostErr)
+		}
+		if lostErr != nil {
+			return fmt.Errorf("connection lost: %v", lostErr)
+		}
+		return errors.New("not connected")
+	}
+	if !sub.IsSubscribed() {
+		return errors.New("not subscribed")
+	}
+	if !statusSub.IsSubscribed() {
+		return errors.New("not subscribed to status events")
+	}
+	return nil
+}
+
+// Завершение работы с NATS: остановка приема сообщений, ожидание обработки полученных, закрытие соединения
+func (sh *StreamingHandler) Finish(ctx context.Context) error {
+	var err error
 	if !sh.isErr {
 		log.Printf("%s: Finish...", sh.name)
-		sh.sub.Unsubscribe()
-		(*sh.conn).Close()
+
+		sh.mutex.Lock()
+		sh.finished = true
+		sh.connected = false
+		conn, sub, statusSub, invSub := sh.conn, sh.sub, sh.statusSub, sh.invSub
+		sh.mutex.Unlock()
+		close(sh.done)
+		metrics.NATSConnected.Set(0)
+
+		if invSub != nil {
+			invSub.Unsubscribe()
+		}
+		if sub != nil {
+			err = sub.Drain(ctx)
+		}
+		if statusSub != nil {
+			if statusErr := statusSub.Drain(ctx); err == nil {
+				err = statusErr
+			}
+		}
+		if conn != nil {
+			(*conn).Close()
+		}
 		log.Printf("%s: Finished!", sh.name)
 	}
+	if sh.embedded != nil {
+		sh.embedded.Shutdown()
+	}
+	return err
 }
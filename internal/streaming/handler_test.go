@@ -0,0 +1,259 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+
+	"github.com/nats-io/nats.go"
+	stan "github.com/nats-io/stan.go"
+)
+
+// Конфигурация NATS для тестов: встроенный сервер, строгий разбор сообщений
+func testNATSConfig(hosts string) config.NATSConfig {
+	return config.NATSConfig{
+		Hosts:               hosts,
+		ClusterID:           "test-cluster",
+		ClientID:            "test-replica",
+		Subject:             "orders",
+		DurableName:         "test",
+		AckWaitSeconds:      1,
+		DLQSubject:          "orders.dlq",
+		MaxRedelivery:       3,
+		DecodePolicy:        config.DecodeStrict,
+		InvalidationSubject: "orders.invalidate",
+		StatusSubject:       "orders.status",
+	}
+}
+
+// Окружение теста: встроенный NATS Streaming, обработчик с MemoryStore и кешем, соединение для публикации
+type testEnv struct {
+	cfg   config.NATSConfig
+	store *db.MemoryStore
+	csh   *db.Cache
+	sh    *StreamingHandler
+	pub   stan.Conn
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	es, err := NewEmbeddedServer("test-cluster")
+	if err != nil {
+		t.Fatalf("NewEmbeddedServer() error: %v", err)
+	}
+	t.Cleanup(es.Shutdown)
+
+	env := testEnv{cfg: testNATSConfig(es.URL()), store: db.NewMemoryStore()}
+	env.csh = testutil.NewCache(t, env.store, 10)
+
+	env.sh = NewStreamingHandler(env.cfg, env.store, env.csh)
+	if err := env.sh.Health(); err != nil {
+		t.Fatalf("handler is not ready: %v", err)
+	}
+	env.pub, err = stan.Connect(env.cfg.ClusterID, "test-publisher", stan.NatsURL(env.cfg.Hosts))
+	if err != nil {
+		t.Fatalf("stan.Connect() error: %v", err)
+	}
+	t.Cleanup(func() { env.pub.Close() })
+	return &env
+}
+
+func (env *testEnv) publish(t *testing.T, subject string, v interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	if err := env.pub.Publish(subject, data); err != nil {
+		t.Fatalf("Publish(%s) error: %v", subject, err)
+	}
+}
+
+// Завершение работы обработчика: все полученные сообщения должны быть обработаны
+func (env *testEnv) finish(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.Wait)
+	defer cancel()
+	if err := env.sh.Finish(ctx); err != nil {
+		t.Fatalf("Finish() error: %v", err)
+	}
+}
+
+func (env *testEnv) waitOrder(t *testing.T, uid string) int64 {
+	t.Helper()
+	var oid int64
+	testutil.WaitFor(t, "order "+uid, func() bool {
+		var err error
+		oid, err = env.store.GetOrderIDByUID(uid)
+		return err == nil
+	})
+	return oid
+}
+
+func TestStreamingHandlerStoresOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.publish(t, env.cfg.Subject, testutil.Order("order-1"))
+	oid := env.waitOrder(t, "order-1")
+	env.finish(t)
+
+	stored, err := env.store.GetOrderByID(oid)
+	if err != nil {
+		t.Fatalf("GetOrderByID(%d) error: %v", oid, err)
+	}
+	if stored.TrackNumber != "TRACK-order-1" || len(stored.Items) != 1 {
+		t.Errorf("stored order = %+v", stored)
+	}
+
+	if size := env.csh.Stats().Size; size != 1 {
+		t.Fatalf("cache size = %d, want 1", size)
+	}
+	cached, err := env.csh.GetOrderByUID("order-1")
+	if err != nil {
+		t.Fatalf("GetOrderByUID() error: %v", err)
+	}
+	if stats := env.csh.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("order is not served from cache: %+v", stats)
+	}
+	if cached.Payment.Amount != 1817 {
+		t.Errorf("cached order = %+v", cached)
+	}
+}
+
+func TestStreamingHandlerSkipsDuplicate(t *testing.T) {
+	env := newTestEnv(t)
+	env.publish(t, env.cfg.Subject, testutil.Order("order-1"))
+	oid := env.waitOrder(t, "order-1")
+
+	// повторная доставка Order с тем же OrderUID не должна изменить сохраненный
+	duplicate := testutil.Order("order-1")
+	duplicate.TrackNumber = "TRACK-duplicate"
+	env.publish(t, env.cfg.Subject, duplicate)
+	// сообщения подписки обрабатываются по порядку: после следующего Order дубликат уже обработан
+	env.publish(t, env.cfg.Subject, testutil.Order("order-2"))
+	env.waitOrder(t, "order-2")
+	env.finish(t)
+
+	page, err := env.store.ListOrders(db.OrderFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListOrders() error: %v", err)
+	}
+	if len(page.Orders) != 2 {
+		t.Fatalf("stored %d orders, want 2", len(page.Orders))
+	}
+	stored, err := env.store.GetOrderByID(oid)
+	if err != nil {
+		t.Fatalf("GetOrderByID(%d) error: %v", oid, err)
+	}
+	if stored.TrackNumber != "TRACK-order-1" {
+		t.Errorf("duplicate overwrote order: track number %q", stored.TrackNumber)
+	}
+}
+
+func TestStreamingHandlerDeadLettersSchemaDrift(t *testing.T) {
+	env := newTestEnv(t)
+	dlq := make(chan db.FailedMessage, 1)
+	sub, err := env.pub.Subscribe(env.cfg.DLQSubject, func(m *stan.Msg) {
+		var fm db.FailedMessage
+		if err := json.Unmarshal(m.Data, &fm); err != nil {
+			t.Errorf("invalid dead letter: %v", err)
+			return
+		}
+		dlq <- fm
+	})
+	if err != nil {
+		t.Fatalf("Subscribe(%s) error: %v", env.cfg.DLQSubject, err)
+	}
+	defer sub.Close()
+
+	data, _ := json.Marshal(testutil.Order("order-drift"))
+	var msg map[string]interface{}
+	json.Unmarshal(data, &msg)
+	msg["gift_wrap"] = true
+	env.publish(t, env.cfg.Subject, msg)
+
+	select {
+	case fm := <-dlq:
+		if fm.Subject != env.cfg.Subject || !strings.Contains(fm.Error, "schema drift") ||
+			!strings.Contains(fm.Error, "gift_wrap") {
+			t.Errorf("dead letter = %+v", fm)
+		}
+	case <-time.After(testutil.Wait):
+		t.Fatal("timeout waiting for dead letter")
+	}
+	env.finish(t)
+
+	if _, err := env.store.GetOrderIDByUID("order-drift"); err != db.ErrOrderNotFound {
+		t.Errorf("order with schema drift stored: err = %v", err)
+	}
+}
+
+func TestStreamingHandlerAppliesStatusEvent(t *testing.T) {
+	env := newTestEnv(t)
+	env.publish(t, env.cfg.Subject, testutil.Order("order-1"))
+	env.waitOrder(t, "order-1")
+
+	env.publish(t, env.cfg.StatusSubject, db.StatusEvent{
+		EventID:    "event-1",
+		OrderUID:   "order-1",
+		Status:     db.StatusPaid,
+		OccurredAt: time.Now().UTC(),
+	})
+	var history db.OrderHistory
+	testutil.WaitFor(t, "status paid", func() bool {
+		var err error
+		history, err = env.csh.GetOrderHistory("order-1")
+		return err == nil && history.Status == db.StatusPaid
+	})
+	env.finish(t)
+
+	if len(history.Events) != 2 {
+		t.Fatalf("history has %d events, want 2", len(history.Events))
+	}
+	event := history.Events[1]
+	if event.EventID != "event-1" || event.PrevStatus != db.StatusCreated {
+		t.Errorf("status event = %+v", event)
+	}
+}
+
+func TestStreamingHandlerBroadcastsInvalidation(t *testing.T) {
+	env := newTestEnv(t)
+	received := make(chan invalidation, 1)
+	sub, err := env.pub.NatsConn().Subscribe(env.cfg.InvalidationSubject, func(m *nats.Msg) {
+		var inv invalidation
+		if err := json.Unmarshal(m.Data, &inv); err != nil {
+			t.Errorf("invalid invalidation message: %v", err)
+			return
+		}
+		received <- inv
+	})
+	if err != nil {
+		t.Fatalf("Subscribe(%s) error: %v", env.cfg.InvalidationSubject, err)
+	}
+	defer sub.Unsubscribe()
+	if err := env.pub.NatsConn().Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	if err := env.sh.BroadcastInvalidation(42); err != nil {
+		t.Fatalf("BroadcastInvalidation() error: %v", err)
+	}
+	select {
+	case inv := <-received:
+		if inv.OrderID != 42 || inv.Origin != env.cfg.ClientID {
+			t.Errorf("invalidation = %+v", inv)
+		}
+	case <-time.After(testutil.Wait):
+		t.Fatal("timeout waiting for invalidation")
+	}
+	env.finish(t)
+
+	// после завершения работы рассылка невозможна: ошибка возвращается вызывающему
+	if err := env.sh.BroadcastInvalidation(42); err == nil {
+		t.Error("BroadcastInvalidation() after Finish() returned nil")
+	}
+}
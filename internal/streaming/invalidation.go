@@ -0,0 +1,100 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	stan "github.com/nats-io/stan.go"
+)
+
+// Уведомление об изменении Order. Рассылается через NATS без Streaming: пропущенное уведомление
+// не восстанавливается, поэтому после переподключения кеш реплики очищается целиком
+type invalidation struct {
+	OrderID int64  `json:"order_id"`
+	Origin  string `json:"origin"` // client id реплики-отправителя: свои уведомления не обрабатываются
+}
+
+// Подписка на уведомления об изменениях Order от других реплик
+func (sh *StreamingHandler) subscribeInvalidations(conn *stan.Conn) (*nats.Subscription, error) {
+	return (*conn).NatsConn().Subscribe(sh.cfg.InvalidationSubject, func(m *nats.Msg) {
+		var inv invalidation
+		if err := json.Unmarshal(m.Data, &inv); err != nil {
+			log.Printf("%s: invalid cache invalidation message: %v\n", sh.name, err)
+			return
+		}
+		if inv.Origin == sh.cfg.ClientID {
+			return
+		}
+		log.Printf("%s: Order (id:%d) changed by %s, invalidating cache\n", sh.name, inv.OrderID, inv.Origin)
+		sh.csh.Invalidate(inv.OrderID)
+	})
+}
+
+// Попытки доставки уведомления об изменении Order, прежде чем запрос обновления получит ошибку
+const (
+	invalidationAttempts     = 3
+	invalidationFlushTimeout = time.Second
+)
+
+// Рассылка уведомления об изменении Order остальным репликам. Если уведомление не доставлено серверу NATS
+// за invalidationAttempts попыток, возвращается ошибка, а доставка повторяется в фоне до успеха или завершения
+// работы: остальные реплики не узнают об изменении сами и отдают прежнюю версию Order до истечения TTL
+func (sh *StreamingHandler) BroadcastInvalidation(oid int64) error {
+	var err error
+	for attempt := 1; attempt <= invalidationAttempts; attempt++ {
+		if err = sh.publishInvalidation(oid); err == nil {
+			return nil
+		}
+		log.Printf("%s: unable to broadcast invalidation of Order (id:%d), attempt %d: %v\n", sh.name, oid, attempt, err)
+		if attempt < invalidationAttempts {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+	go sh.retryInvalidation(oid)
+	return err
+}
+
+// Повтор рассылки уведомления с увеличивающимся интервалом
+func (sh *StreamingHandler) retryInvalidation(oid int64) {
+	delay := time.Second
+	for {
+		select {
+		case <-sh.done:
+			log.Printf("%s: invalidation of Order (id:%d) is not delivered before shutdown\n", sh.name, oid)
+			return
+		case <-time.After(delay):
+		}
+		err := sh.publishInvalidation(oid)
+		if err == nil {
+			return
+		}
+		log.Printf("%s: unable to broadcast invalidation of Order (id:%d): %v\n", sh.name, oid, err)
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// Публикация уведомления и ожидание его получения сервером NATS
+func (sh *StreamingHandler) publishInvalidation(oid int64) error {
+	sh.mutex.RLock()
+	conn, connected := sh.conn, sh.connected
+	sh.mutex.RUnlock()
+	if conn == nil || !connected {
+		return errors.New("not connected")
+	}
+
+	data, _ := json.Marshal(invalidation{OrderID: oid, Origin: sh.cfg.ClientID})
+	nc := (*conn).NatsConn()
+	if err := nc.Publish(sh.cfg.InvalidationSubject, data); err != nil {
+		return err
+	}
+	if err := nc.FlushTimeout(invalidationFlushTimeout); err != nil {
+		return err
+	}
+	log.Printf("%s: invalidation of Order (id:%d) published to %s\n", sh.name, oid, sh.cfg.InvalidationSubject)
+	return nil
+}
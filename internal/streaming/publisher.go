@@ -1,53 +1,162 @@
 package streaming
 
 import (
-	"encoding/json"
+	"context"
 	"log"
-	"os"
-	"wb-test-task/internal/db"
+	"sort"
+	"sync"
+	"time"
 
 	stan "github.com/nats-io/stan.go"
 )
 
+// Параметры публикации
+type PublisherOptions struct {
+	Subject     string
+	Rate        float64 // сообщений в секунду, 0 - без ограничения
+	Concurrency int     // сообщений, одновременно ожидающих подтверждения сервером
+	Async       bool    // PublishAsync: подтверждения обрабатываются в обработчике, а не ожиданием в Publish
+}
+
+// Итоги публикации
+type PublishReport struct {
+	Published int            // всего сообщений: Acked + Failed
+	Acked     int            // подтверждено сервером
+	Failed    int            // не отправлено, отклонено или не подтверждено за PubAckWait
+	Errors    map[string]int // текст ошибки -> число сообщений
+	Latencies []time.Duration
+	Elapsed   time.Duration
+}
+
+// Перцентиль задержки подтверждения, p - от 0 до 100
+func (r *PublishReport) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), r.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p/100*float64(len(sorted))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// Публикация сообщений в NATS Streaming с ограничением скорости и числа неподтвержденных сообщений
 type Publisher struct {
-	sc   *stan.Conn
-	name string
+	sc     *stan.Conn
+	opts   PublisherOptions
+	report PublishReport
+	mutex  *sync.Mutex
+	name   string
 }
 
-func NewPublisher(conn *stan.Conn) *Publisher {
+func NewPublisher(conn *stan.Conn, opts PublisherOptions) *Publisher {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
 	return &Publisher{
-		name: "Publisher",
-		sc:   conn,
+		name:   "Publisher",
+		sc:     conn,
+		opts:   opts,
+		report: PublishReport{Errors: make(map[string]int)},
+		mutex:  &sync.Mutex{},
 	}
 }
 
-// Тестовый скрипт публикации данных Order
-func (p *Publisher) Publish() {
-	// some date to send
-	item1 := db.Items{ChrtID: 1, Price: 10, Rid: "rid 1", Name: "T-Shirt-4", Sale: 9, Size: "M", TotalPrice: 13, NmID: 1, Brand: "Adidas"}
-	item2 := db.Items{ChrtID: 2, Price: 12, Rid: "rid 2", Name: "Jeans", Sale: 11, Size: "S", TotalPrice: 14, NmID: 2, Brand: "Collins"}
-	item3 := db.Items{ChrtID: 3, Price: 18, Rid: "rid 3", Name: "Sneakers", Sale: 15, Size: "M", TotalPrice: 20, NmID: 1, Brand: "Nike"}
-	payment := db.Payment{Transaction: "tran 1", Currency: "Rub", Provider: "Provider 1", Amount: 47, PaymentDt: 2, Bank: "VTB", DeliveryCost: 7, GoodsTotal: 3}
-	order := db.Order{OrderUID: "Order 2", Entry: "2", InternalSignature: "IS 2", Payment: payment, Items: []db.Items{item1, item2, item3},
-		Locale: "Ru", CustomerID: "2", TrackNumber: "2", DeliveryService: "DS 2", Shardkey: "SK 2", SmID: 2}
-	orderData, err := json.Marshal(order)
-	if err != nil {
-		log.Printf("%s: json.Marshal error: %v\n", p.name, err)
+// Публикация всех сообщений из msgs до закрытия канала или отмены ctx. Возвращает итоги после получения
+// подтверждений (или ошибок) по всем отправленным сообщениям
+func (p *Publisher) Run(ctx context.Context, msgs <-chan []byte) PublishReport {
+	var tick <-chan time.Time
+	if p.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / p.opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
 	}
+	sem := make(chan struct{}, p.opts.Concurrency)
+	wg := &sync.WaitGroup{}
+	start := time.Now()
 
-	// An asynchronous publish API
-	ackHandler := func(ackedNuid string, err error) {
-		if err != nil {
-			log.Printf("%s: error publishing msg id %s: %v\n", p.name, ackedNuid, err.Error())
+loop:
+	for {
+		var data []byte
+		var ok bool
+		select {
+		case <-ctx.Done():
+			break loop
+		case data, ok = <-msgs:
+			if !ok {
+				break loop
+			}
+		}
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		if p.opts.Async {
+			p.publishAsync(data, sem, wg)
 		} else {
-			log.Printf("%s: received ack for msg id: %s\n", p.name, ackedNuid)
+			go p.publish(data, sem, wg)
 		}
 	}
 
-	// публикация данных:
-	log.Printf("%s: publishing data ...\n", p.name)
-	nuid, err := (*p.sc).PublishAsync(os.Getenv("NATS_SUBJECT"), orderData, ackHandler) // returns immediately
+	wg.Wait()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.report.Elapsed = time.Since(start)
+	return p.report
+}
+
+// Синхронная публикация: Publish возвращается после подтверждения сервером
+func (p *Publisher) publish(data []byte, sem chan struct{}, wg *sync.WaitGroup) {
+	sent := time.Now()
+	err := (*p.sc).Publish(p.opts.Subject, data)
+	p.acked(sent, err)
+	<-sem
+	wg.Done()
+}
+
+// Асинхронная публикация: подтверждение приходит в обработчик
+func (p *Publisher) publishAsync(data []byte, sem chan struct{}, wg *sync.WaitGroup) {
+	sent := time.Now()
+	_, err := (*p.sc).PublishAsync(p.opts.Subject, data, func(_ string, err error) {
+		p.acked(sent, err)
+		<-sem
+		wg.Done()
+	})
+	if err != nil {
+		// сообщение не отправлено, обработчик вызван не будет
+		p.acked(sent, err)
+		<-sem
+		wg.Done()
+	}
+}
+
+// Учет результата публикации сообщения, отправленного в момент sent
+func (p *Publisher) acked(sent time.Time, err error) {
+	latency := time.Since(sent)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.report.Published++
 	if err != nil {
-		log.Printf("%s: error publishing msg %s: %v\n", p.name, nuid, err.Error())
+		p.report.Failed++
+		p.report.Errors[err.Error()]++
+		log.Printf("%s: error publishing to %s: %v\n", p.name, p.opts.Subject, err)
+		return
 	}
+	p.report.Acked++
+	p.report.Latencies = append(p.report.Latencies, latency)
 }
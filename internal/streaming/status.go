@@ -0,0 +1,64 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/metrics"
+
+	stan "github.com/nats-io/stan.go"
+)
+
+// Подписка на события смены статуса заказов. Долговечное имя подписки - <durable_name>-status,
+// группа подписчиков та же, что и у подписки на Order
+func NewStatusSubscriber(cfg config.NATSConfig, db db.OrderStore, conn *stan.Conn, dl *DeadLetter) *Subscriber {
+	s := newSubscriber("StatusSubscriber", cfg, db, conn, dl)
+	s.subject = cfg.StatusSubject
+	s.durable = cfg.DurableName + "-status"
+	s.handle = s.statusHandler
+	s.acked = metrics.StatusEventsAcked
+	return s
+}
+
+// Обработка события смены статуса. Возвращает true, если сообщение нужно подтвердить
+func (s *Subscriber) statusHandler(m *stan.Msg) bool {
+	attempts := s.attempt(m)
+
+	var e db.StatusEvent
+	err := json.Unmarshal(m.Data, &e)
+	if err == nil {
+		err = e.Validate()
+	}
+	if err != nil {
+		// некорректное событие: повторная доставка не поможет
+		log.Printf("%s: status event rejected: %v\n", s.name, err)
+		metrics.StatusEvents.WithLabelValues(metrics.StatusInvalid).Inc()
+		return s.deadLetter(m, attempts, err)
+	}
+
+	event, err := s.dbObject.ApplyStatusEvent(e)
+	if errors.Is(err, db.ErrEventAlreadyApplied) {
+		log.Printf("%s: status event %s already applied, skipping\n", s.name, e.EventID)
+		metrics.StatusEvents.WithLabelValues(metrics.StatusDuplicate).Inc()
+		return true
+	}
+	if err != nil {
+		// Событие могло прийти раньше Order или предыдущего события (повторная доставка, несколько реплик группы):
+		// ждем повторной доставки, пока не исчерпан лимит попыток, затем отправляем в dead letter
+		log.Printf("%s: unable to apply status event %s to order %s (attempt %d): %v\n", s.name, e.EventID,
+			e.OrderUID, attempts, err)
+		if s.dl.Exhausted(attempts) {
+			metrics.StatusEvents.WithLabelValues(metrics.StatusDeadLetter).Inc()
+			return s.deadLetter(m, attempts, err)
+		}
+		metrics.StatusEvents.WithLabelValues(metrics.StatusRetry).Inc()
+		return false
+	}
+
+	log.Printf("%s: order %s status: %s -> %s\n", s.name, e.OrderUID, event.PrevStatus, event.Status)
+	metrics.StatusEvents.WithLabelValues(metrics.StatusApplied).Inc()
+	metrics.StatusTransitions.WithLabelValues(string(event.Status)).Inc()
+	return true
+}
@@ -1,87 +1,221 @@
 package streaming
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-	"strconv"
+	"sync"
 	"time"
+	"wb-test-task/internal/config"
 	"wb-test-task/internal/db"
+	"wb-test-task/internal/metrics"
+	"wb-test-task/internal/validation"
 
 	stan "github.com/nats-io/stan.go"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Subscriber struct {
+	cfg      config.NATSConfig
+	subject  string
+	durable  string
+	handle   func(m *stan.Msg) bool // обработка сообщения, true - подтвердить
+	acked    prometheus.Counter
 	sub      stan.Subscription
-	dbObject *db.DB
+	dbObject db.OrderStore
+	csh      *db.Cache // сохранение Order в БД и кеш
 	sc       *stan.Conn
+	dl       *DeadLetter
+	decoder  *Decoder
+	stopping bool // идет остановка: новые сообщения не обрабатываются
+	inflight *sync.WaitGroup
+	mutex    *sync.Mutex
 	name     string
 }
 
-func NewSubscriber(db *db.DB, conn *stan.Conn) *Subscriber {
+// Подписка на Order
+func NewSubscriber(cfg config.NATSConfig, db db.OrderStore, csh *db.Cache, conn *stan.Conn, dl *DeadLetter) *Subscriber {
+	s := newSubscriber("Subscriber", cfg, db, conn, dl)
+	s.csh = csh
+	s.subject = cfg.Subject
+	s.durable = cfg.DurableName
+	s.handle = s.messageHandler
+	s.acked = metrics.MessagesAcked
+	return s
+}
+
+func newSubscriber(name string, cfg config.NATSConfig, db db.OrderStore, conn *stan.Conn, dl *DeadLetter) *Subscriber {
 	return &Subscriber{
-		name:     "Subscriber",
+		name:     name,
+		cfg:      cfg,
 		dbObject: db,
 		sc:       conn,
+		dl:       dl,
+		decoder:  NewDecoder(cfg),
+		inflight: &sync.WaitGroup{},
+		mutex:    &sync.Mutex{},
 	}
 }
 
-func (s *Subscriber) Subscribe() {
+func (s *Subscriber) Subscribe() error {
 	// Simple Async Subscriber
 	var err error
 
-	ackWait, err := strconv.Atoi(os.Getenv("NATS_ACK_WAIT_SECONDS"))
-	if err != nil {
-		log.Printf("%s: received a message!\n", s.name)
-		return
-	}
+	handler := func(m *stan.Msg) {
+		// Во время остановки сообщение не обрабатывается и не подтверждается: после AckWait оно будет
+		// доставлено повторно (этой же подписке после перезапуска или другой реплике группы)
+		if !s.begin() {
+			return
+		}
+		defer s.inflight.Done()
 
-	s.sub, err = (*s.sc).Subscribe(
-		os.Getenv("NATS_SUBJECT"),
-		func(m *stan.Msg) {
-			log.Printf("%s: received a message!\n", s.name)
-			if s.messageHandler(m.Data) {
-				err := m.Ack() // в случае успешного сохранения msg уведомляем NATS.
-				if err != nil {
-					log.Printf("%s ack() err: %s", s.name, err)
-				}
+		log.Printf("%s: received a message!\n", s.name)
+		if s.handle(m) {
+			err := m.Ack() // в случае успешного сохранения msg уведомляем NATS.
+			if err != nil {
+				log.Printf("%s ack() err: %s", s.name, err)
+				return
 			}
-		},
-		stan.AckWait(time.Duration(ackWait)*time.Second), // Интервал тайм-аута - AckWait (30 сек default) - ожидание уведомления NATS о чтении сообщения
+			s.acked.Inc()
+		}
+	}
+	opts := []stan.SubscriptionOption{
+		stan.AckWait(time.Duration(s.cfg.AckWaitSeconds) * time.Second), // Интервал тайм-аута - AckWait (30 сек default) - ожидание уведомления NATS о чтении сообщения
 		//stan.DeliverAllAvailable(),                       // DeliverAllAvailable доставит все доступные сообщения
-		stan.DurableName(os.Getenv("NATS_DURABLE_NAME")), // долговечные подписки позволяют клиентам назначить постоянное имя подписке
+		stan.DurableName(s.durable), // долговечные подписки позволяют клиентам назначить постоянное имя подписке
 		// Это приводит к тому, что сервер потоковой передачи NATS отслеживает последнее подтвержденное сообщение для этого clientID + постоянное имя,
 		// так что клиенту будут доставлены только сообщения с момента последнего подтвержденного сообщения.
 		stan.SetManualAckMode(), // ручной режим подтверждения приема сообщения для подписки
-		stan.MaxInflight(10))    // указывает максимальное количество ожидающих подтверждения (сообщений, которые были доставлены, но не подтверждены),
-	// которые NATS Streaming разрешит для данной подписки. При достижении этого предела NATS Streaming приостанавливает доставку сообщений в эту
-	// подписку до тех пор, пока количество неподтвержденных сообщений не упадет ниже указанного предела
+		stan.MaxInflight(10),    // указывает максимальное количество ожидающих подтверждения (сообщений, которые были доставлены, но не подтверждены),
+		// которые NATS Streaming разрешит для данной подписки. При достижении этого предела NATS Streaming приостанавливает доставку сообщений в эту
+		// подписку до тех пор, пока количество неподтвержденных сообщений не упадет ниже указанного предела
+	}
+
+	if s.cfg.QueueGroup != "" {
+		// Долговечная группа подписчиков: каждое сообщение доставляется одной из реплик группы, позиция в канале
+		// хранится для группы целиком (queue group + durable name) и не теряется, пока в группе остается хотя бы одна реплика.
+		// Неподтвержденные репликой сообщения после AckWait доставляются другим участникам группы
+		s.sub, err = (*s.sc).QueueSubscribe(s.subject, s.cfg.QueueGroup, handler, opts...)
+	} else {
+		s.sub, err = (*s.sc).Subscribe(s.subject, handler, opts...)
+	}
 	if err != nil {
 		log.Printf("%s: error: %v\n", s.name, err)
+		return err
+	}
+	if s.cfg.QueueGroup != "" {
+		log.Printf("%s: subscribed to subject %s (queue group %s)\n", s.name, s.subject, s.cfg.QueueGroup)
+		return nil
 	}
-	log.Printf("%s: subscribed to subject %s\n", s.name, os.Getenv("NATS_SUBJECT"))
+	log.Printf("%s: subscribed to subject %s\n", s.name, s.subject)
+	return nil
 }
 
-func (s *Subscriber) messageHandler(data []byte) bool {
-	recievedOrder := db.Order{}
-	err := json.Unmarshal(data, &recievedOrder)
+// Обработка сообщения с Order. Возвращает true, если сообщение нужно подтвердить
+func (s *Subscriber) messageHandler(m *stan.Msg) bool {
+	metrics.MessagesReceived.Inc()
+	attempts := s.attempt(m)
+
+	recievedOrder, err := s.decoder.Decode(m.Data)
 	if err != nil {
 		log.Printf("%s: messageHandler() error, %v\n", s.name, err)
-		// ошибка формата присланных данных. Пропускаем, сообщив серверу, что сообщение получили
-		return true
+		// ошибка формата присланных данных: повторная доставка не поможет, отправляем в dead letter
+		reason := metrics.ReasonInvalidJSON
+		if isSchemaDrift(err) {
+			reason = metrics.ReasonSchemaDrift
+		}
+		metrics.MessagesFailed.WithLabelValues(reason).Inc()
+		return s.deadLetter(m, attempts, err)
 	}
-	log.Printf("%s: unmarshal Order to struct: %v\n", s.name, recievedOrder)
+	log.Printf("%s: unmarshal Order to struct: order_uid %s, %d items\n", s.name, recievedOrder.OrderUID, len(recievedOrder.Items))
 
-	_, err = s.dbObject.AddOrder(recievedOrder)
+	// некорректный Order не сохраняем: отправляем в карантин (dead letter) со списком ошибок по полям
+	if err := validation.ValidateOrder(&recievedOrder); err != nil {
+		log.Printf("%s: order %s rejected: %v\n", s.name, recievedOrder.OrderUID, err)
+		metrics.MessagesFailed.WithLabelValues(metrics.ReasonInvalidOrder).Inc()
+		return s.deadLetter(m, attempts, err)
+	}
+
+	oid, err := s.csh.AddOrder(recievedOrder)
+	if errors.Is(err, db.ErrOrderAlreadyExists) {
+		// повторная доставка уже сохраненного Order: подтверждаем сообщение, не дублируя данные
+		log.Printf("%s: order %s already stored with id %d, skipping\n", s.name, recievedOrder.OrderUID, oid)
+		return true
+	}
 	if err != nil {
-		log.Printf("%s: unable to add order: %v\n", s.name, err)
+		log.Printf("%s: unable to add order (attempt %d): %v\n", s.name, attempts, err)
+		metrics.MessagesFailed.WithLabelValues(metrics.ReasonStorage).Inc()
+		if s.dl.Exhausted(attempts) {
+			return s.deadLetter(m, attempts, err)
+		}
+		return false
+	}
+	return true
+}
+
+// Номер попытки обработки сообщения по числу повторных доставок, которое сообщает NATS Streaming (RedeliveryCount).
+// Локально попытки не считаются: повторная доставка может прийти другой реплике группы
+func (s *Subscriber) attempt(m *stan.Msg) int {
+	return int(m.RedeliveryCount) + 1
+}
+
+// Отправка сообщения в dead letter. Если это не удалось, сообщение не подтверждается и будет доставлено повторно
+func (s *Subscriber) deadLetter(m *stan.Msg, attempts int, reason error) bool {
+	if err := s.dl.Send(m, attempts, reason); err != nil {
+		log.Printf("%s: dead letter error: %v\n", s.name, err)
+		metrics.MessagesFailed.WithLabelValues(metrics.ReasonDeadLetter).Inc()
+		return false
+	}
+	metrics.MessagesDeadLettered.Inc()
+	return true
+}
+
+// Активна ли подписка
+func (s *Subscriber) IsSubscribed() bool {
+	return s != nil && s.sub != nil && s.sub.IsValid()
+}
+
+// Начало обработки сообщения. Возвращает false, если идет остановка
+func (s *Subscriber) begin() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopping {
 		return false
 	}
+	s.inflight.Add(1)
 	return true
 }
 
-func (s *Subscriber) Unsubscribe() {
+// Остановка приема сообщений: ожидание обработки и подтверждения уже полученных сообщений (не дольше срока ctx),
+// затем закрытие подписки
+func (s *Subscriber) Drain(ctx context.Context) error {
+	s.mutex.Lock()
+	s.stopping = true
+	s.mutex.Unlock()
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Printf("%s: in-flight messages processed\n", s.name)
+	case <-ctx.Done():
+		err = fmt.Errorf("in-flight messages are not processed: %w", ctx.Err())
+	}
+	s.Close()
+	return err
+}
+
+// Закрытие подписки. В отличие от Unsubscribe, долговечная подписка (и группа) сохраняется на сервере:
+// после перезапуска доставка продолжится с последнего подтвержденного сообщения
+func (s *Subscriber) Close() {
 	if s.sub != nil {
-		s.sub.Unsubscribe()
+		if err := s.sub.Close(); err != nil {
+			log.Printf("%s: close subscription error: %v\n", s.name, err)
+		}
 	}
 }
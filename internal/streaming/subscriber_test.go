@@ -0,0 +1,219 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+
+	"github.com/nats-io/nats.go"
+	stan "github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// Соединение NATS Streaming без сервера: запоминает опубликованные сообщения
+type fakeConn struct {
+	published []pb.MsgProto
+	err       error // ошибка публикации
+	mutex     sync.Mutex
+}
+
+func (c *fakeConn) Publish(subject string, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.published = append(c.published, pb.MsgProto{Subject: subject, Data: data})
+	return nil
+}
+
+func (c *fakeConn) PublishAsync(subject string, data []byte, ah stan.AckHandler) (string, error) {
+	err := c.Publish(subject, data)
+	if ah != nil {
+		ah("", err)
+	}
+	return "", err
+}
+
+func (c *fakeConn) Subscribe(string, stan.MsgHandler, ...stan.SubscriptionOption) (stan.Subscription, error) {
+	return nil, errUnavailable
+}
+
+func (c *fakeConn) QueueSubscribe(string, string, stan.MsgHandler, ...stan.SubscriptionOption) (stan.Subscription, error) {
+	return nil, errUnavailable
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) NatsConn() *nats.Conn { return nil }
+
+// MemoryStore с отказами записи
+type failingStore struct {
+	*db.MemoryStore
+	addErr    error
+	failedErr error
+}
+
+func (s *failingStore) AddOrder(o db.Order) (int64, error) {
+	if s.addErr != nil {
+		return 0, s.addErr
+	}
+	return s.MemoryStore.AddOrder(o)
+}
+
+func (s *failingStore) AddFailedMessage(fm db.FailedMessage) error {
+	if s.failedErr != nil {
+		return s.failedErr
+	}
+	return s.MemoryStore.AddFailedMessage(fm)
+}
+
+func testMsg(t *testing.T, seq uint64, v interface{}) *stan.Msg {
+	t.Helper()
+	data, ok := v.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			t.Fatalf("json.Marshal() error: %v", err)
+		}
+	}
+	return &stan.Msg{MsgProto: pb.MsgProto{Sequence: seq, Subject: "orders", Data: data}}
+}
+
+func TestSubscriberMessageHandler(t *testing.T) {
+	invalid := testutil.Order("order-invalid")
+	invalid.Payment.Amount = 1
+
+	drift, _ := json.Marshal(testutil.Order("order-drift"))
+	drift = append(drift[:len(drift)-1], []byte(`,"gift_wrap":true}`)...)
+
+	tests := []struct {
+		name      string
+		stored    []db.Order // Order, сохраненные до получения сообщения
+		msg       interface{}
+		store     failingStore
+		pubErr    error
+		deliver   int  // число доставок сообщения
+		ack       bool // результат последней доставки
+		orders    int  // Order в хранилище после обработки
+		deadError string
+	}{
+		{
+			name:    "order is stored",
+			msg:     testutil.Order("order-1"),
+			deliver: 1,
+			ack:     true,
+			orders:  1,
+		},
+		{
+			name:    "duplicate is acked without storing",
+			stored:  []db.Order{testutil.Order("order-1")},
+			msg:     testutil.Order("order-1"),
+			deliver: 1,
+			ack:     true,
+			orders:  1,
+		},
+		{
+			name:      "invalid json is dead-lettered",
+			msg:       []byte(`{"order_uid":`),
+			deliver:   1,
+			ack:       true,
+			deadError: "unexpected end of JSON input",
+		},
+		{
+			name:      "validation failure is dead-lettered",
+			msg:       invalid,
+			deliver:   1,
+			ack:       true,
+			deadError: "payment.amount",
+		},
+		{
+			name:      "schema drift is dead-lettered in strict mode",
+			msg:       drift,
+			deliver:   1,
+			ack:       true,
+			deadError: "unknown fields: gift_wrap",
+		},
+		{
+			name:    "storage error is retried",
+			msg:     testutil.Order("order-1"),
+			store:   failingStore{addErr: errUnavailable},
+			deliver: 2,
+			ack:     false,
+		},
+		{
+			name:      "storage error is dead-lettered after max redelivery",
+			msg:       testutil.Order("order-1"),
+			store:     failingStore{addErr: errUnavailable},
+			deliver:   3,
+			ack:       true,
+			deadError: errUnavailable.Error(),
+		},
+		{
+			name:    "message is not acked if dead letter is unavailable",
+			msg:     invalid,
+			store:   failingStore{failedErr: errUnavailable},
+			pubErr:  errUnavailable,
+			deliver: 1,
+			ack:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testNATSConfig("")
+			store := tt.store
+			store.MemoryStore = db.NewMemoryStore()
+			for _, o := range tt.stored {
+				if _, err := store.MemoryStore.AddOrder(o); err != nil {
+					t.Fatalf("AddOrder() error: %v", err)
+				}
+			}
+			fc := &fakeConn{err: tt.pubErr}
+			var conn stan.Conn = fc
+			csh := testutil.NewCache(t, &store, 10)
+			s := NewSubscriber(cfg, &store, csh, &conn, NewDeadLetter(cfg, &store, &conn))
+
+			var ack bool
+			for i := 0; i < tt.deliver; i++ {
+				// номер повторной доставки сообщает сервер
+				m := testMsg(t, 1, tt.msg)
+				m.Redelivered, m.RedeliveryCount = i > 0, uint32(i)
+				ack = s.messageHandler(m)
+			}
+			if ack != tt.ack {
+				t.Errorf("ack = %v, want %v", ack, tt.ack)
+			}
+
+			page, err := store.ListOrders(db.OrderFilter{Limit: 10})
+			if err != nil {
+				t.Fatalf("ListOrders() error: %v", err)
+			}
+			if len(page.Orders) != tt.orders {
+				t.Errorf("stored %d orders, want %d", len(page.Orders), tt.orders)
+			}
+
+			if tt.deadError == "" {
+				if len(fc.published) > 0 {
+					t.Errorf("unexpected dead letter: %s", fc.published[0].Data)
+				}
+				return
+			}
+			if len(fc.published) != 1 || fc.published[0].Subject != cfg.DLQSubject {
+				t.Fatalf("published %+v, want one dead letter", fc.published)
+			}
+			var fm db.FailedMessage
+			if err := json.Unmarshal(fc.published[0].Data, &fm); err != nil {
+				t.Fatalf("invalid dead letter: %v", err)
+			}
+			if fm.Attempts != tt.deliver || !strings.Contains(fm.Error, tt.deadError) {
+				t.Errorf("dead letter = %+v, want %d attempts and error %q", fm, tt.deliver, tt.deadError)
+			}
+		})
+	}
+}
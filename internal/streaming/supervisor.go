@@ -0,0 +1,69 @@
+package streaming
+
+import (
+	"log"
+	"time"
+	"wb-test-task/internal/metrics"
+)
+
+// Интервалы между попытками переподключения: от 1 секунды с удвоением до 30 секунд
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// Супервизор соединения: ждет уведомления о потере соединения и восстанавливает его до вызова Finish()
+func (sh *StreamingHandler) supervise() {
+	for {
+		select {
+		case <-sh.done:
+			return
+		case reason := <-sh.lost:
+			sh.reconnect(reason)
+		}
+	}
+}
+
+// Переподключение с увеличивающимся интервалом. Старое соединение закрывается: после потери
+// соединения stan.Conn не восстанавливается, а сервер может еще считать клиента подключенным
+func (sh *StreamingHandler) reconnect(reason error) {
+	log.Printf("%s: reconnecting, reason: %v\n", sh.name, reason)
+
+	sh.mutex.Lock()
+	old := sh.conn
+	sh.conn = nil
+	sh.mutex.Unlock()
+	if old != nil {
+		(*old).Close()
+	}
+
+	delay := reconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-sh.done:
+			return
+		case <-time.After(delay):
+		}
+
+		sh.mutex.Lock()
+		sh.attempt = attempt
+		sh.mutex.Unlock()
+
+		err := sh.connectAndSubscribe()
+		if err == nil {
+			metrics.NATSReconnectAttempts.WithLabelValues("ok").Inc()
+			log.Printf("%s: reconnected and resubscribed after %d attempts\n", sh.name, attempt)
+			return
+		}
+		metrics.NATSReconnectAttempts.WithLabelValues("error").Inc()
+		log.Printf("%s: reconnect attempt %d failed: %v\n", sh.name, attempt, err)
+
+		sh.mutex.Lock()
+		sh.lostErr = err
+		sh.mutex.Unlock()
+
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+	"wb-test-task/internal/config"
+	"wb-test-task/internal/db"
+)
+
+// Срок ожидания асинхронной обработки в тестах
+const Wait = 5 * time.Second
+
+// Корректный Order с заполненными полями модели: проходит валидацию и строгий разбор сообщений
+func Order(uid string) db.Order {
+	return db.Order{
+		OrderUID:    uid,
+		Entry:       "WBIL",
+		TrackNumber: "TRACK-" + uid,
+		Delivery: db.Delivery{
+			Name:    "Test Testov",
+			Phone:   "+9720000000",
+			Zip:     "2639809",
+			City:    "Kiryat Mozkin",
+			Address: "Ploshad Mira 15",
+			Region:  "Kraiot",
+			Email:   "test@gmail.com",
+		},
+		Payment: db.Payment{
+			Transaction:  uid,
+			Currency:     "USD",
+			Provider:     "wbpay",
+			Amount:       1817,
+			PaymentDt:    1637907727,
+			Bank:         "alpha",
+			DeliveryCost: 1500,
+			GoodsTotal:   317,
+		},
+		Items: []db.Items{{
+			ChrtID:     9934930,
+			Price:      453,
+			Rid:        "ab4219087a764ae0btest",
+			Name:       "Mascaras",
+			Sale:       30,
+			Size:       "0",
+			TotalPrice: 317,
+			NmID:       2389212,
+			Brand:      "Vivienne Sabo",
+		}},
+		Locale:          "en",
+		CustomerID:      "test",
+		DeliveryService: "meest",
+		Shardkey:        "9",
+		SmID:            99,
+		DateCreated:     time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+		OofShard:        "1",
+	}
+}
+
+// Кеш размера size поверх хранилища store, завершается вместе с тестом
+func NewCache(t *testing.T, store db.OrderStore, size int) *db.Cache {
+	t.Helper()
+	csh := db.NewCache(config.CacheConfig{Size: size, AppKey: "test", ShutdownPolicy: config.CacheShutdownWipe}, store)
+	t.Cleanup(csh.Finish)
+	return csh
+}
+
+// Сохранение Order через кеш. Возвращает id сохраненных Order по OrderUID
+func AddOrders(t *testing.T, csh *db.Cache, uids ...string) map[string]int64 {
+	t.Helper()
+	oids := make(map[string]int64, len(uids))
+	for _, uid := range uids {
+		oid, err := csh.AddOrder(Order(uid))
+		if err != nil {
+			t.Fatalf("AddOrder(%s) error: %v", uid, err)
+		}
+		oids[uid] = oid
+	}
+	return oids
+}
+
+// Ожидание выполнения условия не дольше Wait
+func WaitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(Wait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"wb-test-task/internal/db"
+)
+
+// Допустимое расхождение total_price с price и sale из-за округления
+const priceTolerance = 1
+
+// Ошибка валидации конкретного поля Order
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Список ошибок валидации Order
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid order: " + strings.Join(msgs, "; ")
+}
+
+func (e *Errors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Проверка Order перед сохранением. Возвращает nil или Errors со списком ошибок по полям
+func ValidateOrder(o *db.Order) error {
+	var errs Errors
+
+	if strings.TrimSpace(o.OrderUID) == "" {
+		errs.add("order_uid", "must not be empty")
+	}
+	if len(o.Items) == 0 {
+		errs.add("items", "must contain at least one item")
+	}
+	for i, item := range o.Items {
+		validateItem(&errs, fmt.Sprintf("items[%d]", i), item)
+	}
+	validatePayment(&errs, o.Payment)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Проверка позиции заказа: цены неотрицательны, скидка в процентах, total_price = price * (100 - sale) / 100
+func validateItem(errs *Errors, prefix string, item db.Items) {
+	if item.Price < 0 {
+		errs.add(prefix+".price", "must not be negative, got %d", item.Price)
+	}
+	if item.TotalPrice < 0 {
+		errs.add(prefix+".total_price", "must not be negative, got %d", item.TotalPrice)
+	}
+	if item.Sale < 0 || item.Sale > 100 {
+		errs.add(prefix+".sale", "must be between 0 and 100, got %d", item.Sale)
+		return
+	}
+	expected := item.Price * (100 - item.Sale) / 100
+	if diff := item.TotalPrice - expected; diff > priceTolerance || diff < -priceTolerance {
+		errs.add(prefix+".total_price", "must equal price with sale applied (%d), got %d", expected, item.TotalPrice)
+	}
+}
+
+// Проверка оплаты: суммы неотрицательны, amount = goods_total + delivery_cost
+func validatePayment(errs *Errors, p db.Payment) {
+	if p.Amount < 0 {
+		errs.add("payment.amount", "must not be negative, got %d", p.Amount)
+	}
+	if p.GoodsTotal < 0 {
+		errs.add("payment.goods_total", "must not be negative, got %d", p.GoodsTotal)
+	}
+	if p.DeliveryCost < 0 {
+		errs.add("payment.delivery_cost", "must not be negative, got %d", p.DeliveryCost)
+	}
+	if p.Amount != p.GoodsTotal+p.DeliveryCost {
+		errs.add("payment.amount", "must equal goods_total + delivery_cost (%d), got %d", p.GoodsTotal+p.DeliveryCost, p.Amount)
+	}
+}
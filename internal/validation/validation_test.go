@@ -0,0 +1,102 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"wb-test-task/internal/db"
+	"wb-test-task/internal/testutil"
+)
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(o *db.Order)
+		fields []string // поля с ошибками в порядке проверки, пусто - Order корректен
+	}{
+		{
+			name:   "valid order",
+			mutate: func(o *db.Order) {},
+		},
+		{
+			name:   "total price within rounding tolerance",
+			mutate: func(o *db.Order) { o.Items[0].Price, o.Items[0].Sale, o.Items[0].TotalPrice = 99, 50, 50 },
+		},
+		{
+			name:   "empty order_uid",
+			mutate: func(o *db.Order) { o.OrderUID = "  " },
+			fields: []string{"order_uid"},
+		},
+		{
+			name:   "no items",
+			mutate: func(o *db.Order) { o.Items = nil },
+			fields: []string{"items"},
+		},
+		{
+			name:   "negative price",
+			mutate: func(o *db.Order) { o.Items[0].Price, o.Items[0].Sale, o.Items[0].TotalPrice = -10, 0, -10 },
+			fields: []string{"items[0].price", "items[0].total_price"},
+		},
+		{
+			name:   "sale out of range",
+			mutate: func(o *db.Order) { o.Items[0].Sale = 101 },
+			fields: []string{"items[0].sale"},
+		},
+		{
+			name:   "total price does not match sale",
+			mutate: func(o *db.Order) { o.Items[0].TotalPrice = 453 },
+			fields: []string{"items[0].total_price"},
+		},
+		{
+			name: "second item is checked",
+			mutate: func(o *db.Order) {
+				item := o.Items[0]
+				item.Sale = -1
+				o.Items = append(o.Items, item)
+			},
+			fields: []string{"items[1].sale"},
+		},
+		{
+			name:   "negative payment sums",
+			mutate: func(o *db.Order) { o.Payment.Amount, o.Payment.GoodsTotal, o.Payment.DeliveryCost = -2, -1, -1 },
+			fields: []string{"payment.amount", "payment.goods_total", "payment.delivery_cost"},
+		},
+		{
+			name:   "amount does not match goods_total and delivery_cost",
+			mutate: func(o *db.Order) { o.Payment.Amount = 1 },
+			fields: []string{"payment.amount"},
+		},
+		{
+			name: "all errors are reported",
+			mutate: func(o *db.Order) {
+				o.OrderUID = ""
+				o.Items[0].Sale = 200
+				o.Payment.DeliveryCost = 0
+			},
+			fields: []string{"order_uid", "items[0].sale", "payment.amount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testutil.Order("order-1")
+			tt.mutate(&o)
+			err := ValidateOrder(&o)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("ValidateOrder() error: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("ValidateOrder() error = %v, want Errors", err)
+			}
+			fields := make([]string, 0, len(errs))
+			for _, fe := range errs {
+				fields = append(fields, fe.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("fields = %v, want %v (%v)", fields, tt.fields, err)
+			}
+		})
+	}
+}